@@ -2,10 +2,113 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
+	"time"
 )
 
+// ErrNotSupported is returned by operations a cache backend can't perform
+var ErrNotSupported = errors.New("operation not supported by cache")
+
 // Cache is an interface for caching ip addresses
 type Cache interface {
-	Get(context.Context, string) (bool, bool, error)
-	Set(context.Context, string, bool) error
+	Get(context.Context, string) (*Entry, bool, error)
+	Set(context.Context, string, *Entry) error
+	Delete(context.Context, string) error
+	// Flush removes all entries
+	Flush(context.Context) error
+	// Len returns the number of entries
+	Len(context.Context) (int, error)
+	// Range calls fn for each entry until fn returns false
+	Range(context.Context, func(key string, entry *Entry) bool) error
+	Stats() Stats
+}
+
+// Stats holds cache usage counters
+type Stats struct {
+	// Hits counts lookups that found an entry
+	Hits uint64
+	// Misses counts lookups that didn't find an entry
+	Misses uint64
+	// Evictions counts entries removed by expiration, Delete or Flush.
+	// Expirations handled by a remote server, such as redis, aren't observed.
+	Evictions uint64
+}
+
+// counters tracks Stats and is safe for concurrent use
+type counters struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// lookup counts a hit or miss
+func (c *counters) lookup(found bool) {
+	if found {
+		atomic.AddUint64(&c.hits, 1)
+		return
+	}
+	atomic.AddUint64(&c.misses, 1)
+}
+
+func (c *counters) evicted(n int) {
+	atomic.AddUint64(&c.evictions, uint64(n))
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+// Entry is the cached proximity result of an ip address
+type Entry struct {
+	// StoredAt is when the result was looked up. Set automatically if empty.
+	StoredAt time.Time `json:"stored_at"`
+	// Fingerprint identifies the geofence the result was decided against. Results of another geofence are ignored.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Proximity is where the location lies relative to the geofence, empty for entries stored by older versions
+	Proximity string `json:"proximity,omitempty"`
+	// IsIPAddressNear is the cached proximity result
+	IsIPAddressNear bool `json:"is_ip_address_near"`
+	// Stale is set by Get when the entry has outlived its SoftTTL but not its TTL.
+	// It can still be used while it's refreshed in the background.
+	Stale bool `json:"-"`
+	// Expired is set by Get when the entry has outlived its TTL but is still
+	// retained for StaleTTL so it can be served if a lookup fails
+	Expired bool `json:"-"`
+}
+
+// stamp returns a copy of value with StoredAt set and the age of the entry
+func stamp(value *Entry) (Entry, time.Duration) {
+	entry := *value
+	if entry.StoredAt.IsZero() {
+		entry.StoredAt = time.Now()
+		return entry, 0
+	}
+	return entry, time.Since(entry.StoredAt)
+}
+
+// retention returns how long the backend should keep an entry of the given age.
+// A TTL <= 0 keeps entries indefinitely and returns 0.
+// ok is false if the entry should not be stored at all.
+func retention(age, ttl, staleTTL time.Duration) (time.Duration, bool) {
+	if ttl <= 0 {
+		return 0, true
+	}
+	if staleTTL < 0 {
+		staleTTL = 0
+	}
+	remaining := ttl + staleTTL - age
+	return remaining, remaining > 0
+}
+
+// isExpired reports whether an entry stored at storedAt has outlived ttl
+func isExpired(storedAt time.Time, ttl time.Duration) bool {
+	if ttl <= 0 || storedAt.IsZero() {
+		return false
+	}
+	return time.Since(storedAt) > ttl
 }
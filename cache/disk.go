@@ -0,0 +1,466 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultCompactionInterval = time.Hour
+	diskOpenTimeout           = time.Second
+	diskCompactTxMaxSize      = 64 * 1024
+	diskCompactSuffix         = ".compact"
+)
+
+var (
+	diskBucket = []byte("geofence")
+	// reopenDiskDB opens the database file again after a compaction
+	reopenDiskDB = openDiskDB
+)
+
+// DiskCache is used to store/fetch ip proximity from a local bbolt database file.
+// Writes are transactional so the file survives crashes and restarts.
+type DiskCache struct {
+	// db is nil once it couldn't be opened again after a compaction, unavailable is returned instead
+	db          *bolt.DB
+	unavailable error
+	diskOptions *DiskOptions
+	done        chan struct{}
+	counters    counters
+	// writes counts changes, so compaction notices the ones made while it copied the file
+	writes uint64
+	// mu guards db while it is swapped for a compacted copy
+	mu sync.RWMutex
+	wg sync.WaitGroup
+	// compacting runs one compaction at a time
+	compacting sync.Mutex
+	closeOnce  sync.Once
+}
+
+// DiskOptions holds on-disk cache configuration parameters.
+type DiskOptions struct {
+	// Path of the database file. It is created if it doesn't exist.
+	Path     string
+	TTL      time.Duration
+	SoftTTL  time.Duration
+	StaleTTL time.Duration
+	// CompactionInterval is how often expired entries are removed and the file is compacted.
+	// Defaults to 1 hour, disabled if negative.
+	CompactionInterval time.Duration
+}
+
+// NewDiskCache opens or creates the database file and provides a new on-disk cache client.
+func NewDiskCache(diskOptions *DiskOptions) (*DiskCache, error) {
+	// Leftover from a compaction that didn't finish, the original file is still intact
+	_ = os.Remove(diskOptions.Path + diskCompactSuffix)
+
+	db, err := openDiskDB(diskOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DiskCache{
+		db:          db,
+		diskOptions: diskOptions,
+		done:        make(chan struct{}),
+	}
+
+	interval := diskOptions.CompactionInterval
+	if interval == 0 {
+		interval = defaultCompactionInterval
+	}
+	if interval > 0 {
+		d.wg.Add(1)
+		go d.compactEvery(interval)
+	}
+
+	return d, nil
+}
+
+// openDiskDB opens the database file and ensures the bucket exists
+func openDiskDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: diskOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Get gets value from the on-disk cache.
+func (d *DiskCache) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	db, err := d.open()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var entry *Entry
+	err = db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket(diskBucket).Get([]byte(key))
+		if val == nil {
+			return nil
+		}
+
+		decoded, expiresAt, err := decodeDiskEntry(val)
+		if err != nil {
+			return err
+		}
+		// Expired entries are removed by the next compaction
+		if !expiresAt.IsZero() && time.Now().After(expiresAt) {
+			return nil
+		}
+		entry = decoded
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	d.counters.lookup(entry != nil)
+	if entry == nil {
+		return nil, false, nil
+	}
+	d.mark(entry)
+
+	return entry, true, nil
+}
+
+// mark sets whether an entry read from the database is stale or expired
+func (d *DiskCache) mark(entry *Entry) {
+	entry.Expired = isExpired(entry.StoredAt, d.diskOptions.TTL)
+	entry.Stale = !entry.Expired && isExpired(entry.StoredAt, d.diskOptions.SoftTTL)
+}
+
+// Set sets k/v in the on-disk cache.
+func (d *DiskCache) Set(ctx context.Context, key string, value *Entry) error {
+	entry, age := stamp(value)
+	ttl, ok := retention(age, d.diskOptions.TTL, d.diskOptions.StaleTTL)
+	if !ok {
+		return nil
+	}
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	val, err := encodeDiskEntry(&entry, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	db, err := d.open()
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.writes, 1)
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskBucket).Put([]byte(key), val)
+	})
+}
+
+// Compact removes expired entries and rewrites the database file to reclaim their space.
+// Requests are served while the file is copied and only wait for the copy to replace the original.
+// The compacted copy replaces the original with an atomic rename, so a crash leaves either file intact.
+// If the file can't be opened again afterwards, every method returns the error.
+func (d *DiskCache) Compact() error {
+	d.compacting.Lock()
+	defer d.compacting.Unlock()
+
+	path := d.diskOptions.Path
+	compactPath := path + diskCompactSuffix
+
+	d.mu.RLock()
+	writes := atomic.LoadUint64(&d.writes)
+	db, err := d.open()
+	if err == nil {
+		err = d.deleteExpired(db)
+	}
+	if err == nil {
+		err = copyDiskDB(db, compactPath)
+	}
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Changes made while copying aren't in the copy, so copy again while they're blocked
+	if atomic.LoadUint64(&d.writes) != writes {
+		err = copyDiskDB(d.db, compactPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The original stays open until the compacted copy is in place
+	err = d.db.Close()
+	if err != nil {
+		_ = os.Remove(compactPath)
+		return err
+	}
+
+	renameErr := os.Rename(compactPath, path)
+	if renameErr != nil {
+		_ = os.Remove(compactPath)
+	}
+
+	d.db, err = reopenDiskDB(path)
+	if err != nil {
+		d.unavailable = fmt.Errorf("disk cache unavailable after compaction: %w", err)
+		return d.unavailable
+	}
+
+	return renameErr
+}
+
+// copyDiskDB writes a compacted copy of db to path, replacing any previous copy
+func copyDiskDB(db *bolt.DB, path string) error {
+	_ = os.Remove(path)
+	dst, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: diskOpenTimeout})
+	if err != nil {
+		return err
+	}
+
+	err = bolt.Compact(dst, db, diskCompactTxMaxSize)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// open returns the database, or the error that made it unavailable. The caller must hold d.mu.
+func (d *DiskCache) open() (*bolt.DB, error) {
+	if d.db == nil {
+		return nil, d.unavailable
+	}
+	return d.db, nil
+}
+
+// deleteExpired removes entries past their expiration
+func (d *DiskCache) deleteExpired(db *bolt.DB) error {
+	now := time.Now()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskBucket)
+
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			_, expiresAt, err := decodeDiskEntry(v)
+			if err != nil || (!expiresAt.IsZero() && now.After(expiresAt)) {
+				// Keys are only valid for the life of the transaction, and can't be deleted while iterating
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		d.counters.evicted(len(expired))
+		return nil
+	})
+}
+
+// Delete removes k from the on-disk cache.
+func (d *DiskCache) Delete(ctx context.Context, key string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	db, err := d.open()
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.writes, 1)
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskBucket)
+		if bucket.Get([]byte(key)) == nil {
+			return nil
+		}
+		err := bucket.Delete([]byte(key))
+		if err == nil {
+			d.counters.evicted(1)
+		}
+		return err
+	})
+}
+
+// Flush removes all keys from the on-disk cache.
+func (d *DiskCache) Flush(ctx context.Context) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	db, err := d.open()
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.writes, 1)
+	return db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(diskBucket).Stats().KeyN
+		err := tx.DeleteBucket(diskBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket(diskBucket)
+		if err == nil {
+			d.counters.evicted(keys)
+		}
+		return err
+	})
+}
+
+// Len returns the number of unexpired keys in the on-disk cache.
+func (d *DiskCache) Len(ctx context.Context) (int, error) {
+	count := 0
+	err := d.Range(ctx, func(string, *Entry) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+// Range calls fn for each unexpired k/v in the on-disk cache until fn returns false.
+// Entries are read up front, so fn may use the cache.
+func (d *DiskCache) Range(ctx context.Context, fn func(key string, entry *Entry) bool) error {
+	type item struct {
+		entry *Entry
+		key   string
+	}
+	var items []item
+
+	d.mu.RLock()
+	db, err := d.open()
+	if err != nil {
+		d.mu.RUnlock()
+		return err
+	}
+	now := time.Now()
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskBucket).ForEach(func(k, v []byte) error {
+			entry, expiresAt, err := decodeDiskEntry(v)
+			if err != nil {
+				return err
+			}
+			if expiresAt.IsZero() || now.Before(expiresAt) {
+				items = append(items, item{key: string(k), entry: entry})
+			}
+			return nil
+		})
+	})
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range items {
+		d.mark(i.entry)
+		if !fn(i.key, i.entry) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Stats returns the on-disk cache usage counters.
+func (d *DiskCache) Stats() Stats {
+	return d.counters.stats()
+}
+
+// compactEvery runs Compact on an interval until Close is called
+func (d *DiskCache) compactEvery(interval time.Duration) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = d.Compact()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Close stops compaction and closes the database file. Later calls do nothing.
+func (d *DiskCache) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.wg.Wait()
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if d.db != nil {
+			err = d.db.Close()
+		}
+	})
+	return err
+}
+
+// encodeDiskEntry prefixes the json encoded entry with its expiration in unix nanoseconds, 0 if it never expires
+func encodeDiskEntry(entry *Entry, expiresAt time.Time) ([]byte, error) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	val := make([]byte, 8, 8+len(encoded))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(val, uint64(expiresAt.UnixNano()))
+	}
+	return append(val, encoded...), nil
+}
+
+// decodeDiskEntry parses a value written by encodeDiskEntry
+func decodeDiskEntry(val []byte) (*Entry, time.Time, error) {
+	if len(val) < 8 {
+		return nil, time.Time{}, errors.New("corrupt disk cache entry")
+	}
+
+	var expiresAt time.Time
+	if nanos := binary.BigEndian.Uint64(val[:8]); nanos != 0 {
+		expiresAt = time.Unix(0, int64(nanos))
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(val[8:], entry); err != nil {
+		return nil, time.Time{}, err
+	}
+	return entry, expiresAt, nil
+}
@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestNewDiskCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geofence.db")
+
+	// Leftover from an interrupted compaction
+	err := os.WriteFile(path+diskCompactSuffix, []byte("partial"), 0o600)
+	assert.NoError(t, err)
+
+	actual, err := NewDiskCache(&DiskOptions{Path: path})
+	assert.NoError(t, err)
+	assert.NotNil(t, actual)
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, path+diskCompactSuffix)
+	assert.NoError(t, actual.Close())
+	// Closing again does nothing
+	assert.NoError(t, actual.Close())
+
+	// Missing directory
+	_, err = NewDiskCache(&DiskOptions{Path: filepath.Join(t.TempDir(), "missing", "geofence.db")})
+	assert.Error(t, err)
+}
+
+func TestDiskGetAndSet(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    bool
+		exists   bool
+		expected bool
+	}{
+		// Ensure key not present
+		{
+			key:      "testkey1",
+			value:    true,
+			exists:   false,
+			expected: false,
+		},
+		// Ensure value was set to true
+		{
+			key:      "testkey2",
+			value:    true,
+			exists:   true,
+			expected: true,
+		},
+		// Ensure value was set to false
+		{
+			key:      "testkey3",
+			value:    false,
+			exists:   true,
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		client, err := NewDiskCache(&DiskOptions{Path: filepath.Join(t.TempDir(), "geofence.db")})
+		assert.NoError(t, err)
+
+		if test.exists {
+			assert.NoError(t, client.Set(context.TODO(), test.key, &Entry{IsIPAddressNear: test.value}))
+		}
+
+		val, exists, err := client.Get(context.TODO(), test.key)
+		assert.NoError(t, err)
+		assert.Equal(t, test.exists, exists)
+		if test.exists {
+			assert.Equal(t, test.expected, val.IsIPAddressNear)
+		}
+		assert.NoError(t, client.Close())
+	}
+}
+
+func TestDiskPersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geofence.db")
+
+	client, err := NewDiskCache(&DiskOptions{Path: path, TTL: time.Hour})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Set(context.TODO(), "testkey", &Entry{IsIPAddressNear: true}))
+	assert.NoError(t, client.Close())
+
+	client, err = NewDiskCache(&DiskOptions{Path: path, TTL: time.Hour})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	val, exists, err := client.Get(context.TODO(), "testkey")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.True(t, val.IsIPAddressNear)
+}
+
+func TestDiskExpiredAndStale(t *testing.T) {
+	tests := []struct {
+		age     time.Duration
+		exists  bool
+		stale   bool
+		expired bool
+	}{
+		// Fresh entry
+		{
+			age:    time.Second,
+			exists: true,
+		},
+		// Past SoftTTL, still usable
+		{
+			age:    45 * time.Second,
+			exists: true,
+			stale:  true,
+		},
+		// Past TTL but retained for StaleTTL
+		{
+			age:     90 * time.Second,
+			exists:  true,
+			expired: true,
+		},
+		// Past TTL and StaleTTL, never stored
+		{
+			age:    3 * time.Minute,
+			exists: false,
+		},
+	}
+	for _, test := range tests {
+		client, err := NewDiskCache(&DiskOptions{
+			Path:     filepath.Join(t.TempDir(), "geofence.db"),
+			TTL:      time.Minute,
+			SoftTTL:  30 * time.Second,
+			StaleTTL: time.Minute,
+		})
+		assert.NoError(t, err)
+
+		err = client.Set(context.TODO(), "testkey", &Entry{
+			StoredAt:        time.Now().Add(-test.age),
+			IsIPAddressNear: true,
+		})
+		assert.NoError(t, err)
+
+		val, exists, err := client.Get(context.TODO(), "testkey")
+		assert.NoError(t, err)
+		assert.Equal(t, test.exists, exists)
+		if test.exists {
+			assert.Equal(t, test.stale, val.Stale)
+			assert.Equal(t, test.expired, val.Expired)
+		}
+		assert.NoError(t, client.Close())
+	}
+}
+
+func TestDiskCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geofence.db")
+	client, err := NewDiskCache(&DiskOptions{
+		Path:               path,
+		TTL:                time.Minute,
+		CompactionInterval: -1,
+	})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	assert.NoError(t, client.Set(context.TODO(), "fresh", &Entry{IsIPAddressNear: true}))
+	putExpiredDiskEntry(t, client, "expiring")
+
+	assert.NoError(t, client.Compact())
+	assert.NoFileExists(t, path+diskCompactSuffix)
+
+	// Expired entry was removed from the file
+	err = client.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, 1, tx.Bucket(diskBucket).Stats().KeyN)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	_, exists, err := client.Get(context.TODO(), "expiring")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Still usable after the file was swapped
+	val, exists, err := client.Get(context.TODO(), "fresh")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.True(t, val.IsIPAddressNear)
+	assert.NoError(t, client.Set(context.TODO(), "after", &Entry{IsIPAddressNear: false}))
+}
+
+func TestDiskCompactConcurrentWrites(t *testing.T) {
+	client, err := NewDiskCache(&DiskOptions{
+		Path:               filepath.Join(t.TempDir(), "geofence.db"),
+		TTL:                time.Minute,
+		CompactionInterval: -1,
+	})
+	assert.NoError(t, err)
+	defer client.Close()
+	ctx := context.TODO()
+
+	// Writes made while the file is copied are kept
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, client.Set(ctx, fmt.Sprintf("key%d", i), &Entry{IsIPAddressNear: true}))
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, client.Compact())
+	}
+	<-done
+
+	length, err := client.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, length)
+}
+
+func TestDiskCompactReopenFails(t *testing.T) {
+	client, err := NewDiskCache(&DiskOptions{
+		Path:               filepath.Join(t.TempDir(), "geofence.db"),
+		TTL:                time.Minute,
+		CompactionInterval: -1,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Set(context.TODO(), "fresh", &Entry{IsIPAddressNear: true}))
+
+	reopenErr := errors.New("reopen failed")
+	reopenDiskDB = func(path string) (*bolt.DB, error) { return nil, reopenErr }
+	defer func() { reopenDiskDB = openDiskDB }()
+
+	err = client.Compact()
+	assert.ErrorIs(t, err, reopenErr)
+
+	// Every method returns the error instead of using the closed file
+	ctx := context.TODO()
+	_, _, err = client.Get(ctx, "fresh")
+	assert.ErrorIs(t, err, reopenErr)
+	assert.ErrorIs(t, client.Set(ctx, "key", &Entry{}), reopenErr)
+	assert.ErrorIs(t, client.Delete(ctx, "fresh"), reopenErr)
+	assert.ErrorIs(t, client.Flush(ctx), reopenErr)
+	_, err = client.Len(ctx)
+	assert.ErrorIs(t, err, reopenErr)
+	assert.ErrorIs(t, client.Compact(), reopenErr)
+	assert.NoError(t, client.Close())
+}
+
+func TestDiskManagement(t *testing.T) {
+	client, err := NewDiskCache(&DiskOptions{Path: filepath.Join(t.TempDir(), "geofence.db"), TTL: time.Minute})
+	assert.NoError(t, err)
+	defer client.Close()
+	ctx := context.TODO()
+
+	for _, key := range []string{"testkey1", "testkey2", "testkey3"} {
+		assert.NoError(t, client.Set(ctx, key, &Entry{IsIPAddressNear: true}))
+	}
+	// Expired entries are skipped
+	putExpiredDiskEntry(t, client, "expiring")
+
+	length, err := client.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, length)
+
+	seen := map[string]bool{}
+	err = client.Range(ctx, func(key string, entry *Entry) bool {
+		seen[key] = entry.IsIPAddressNear
+		// Entries are read up front so the cache can be used from fn
+		assert.NoError(t, client.Set(ctx, key, entry))
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"testkey1": true, "testkey2": true, "testkey3": true}, seen)
+
+	assert.NoError(t, client.Delete(ctx, "testkey1"))
+	// Deleting a missing key isn't an eviction
+	assert.NoError(t, client.Delete(ctx, "missing"))
+	_, exists, err := client.Get(ctx, "testkey1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, exists, err = client.Get(ctx, "testkey2")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, client.Flush(ctx))
+	length, err = client.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, length)
+
+	// testkey1 and the 3 remaining keys, including the expired one not yet compacted
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Evictions: 4}, client.Stats())
+}
+
+// putExpiredDiskEntry writes an entry already past its expiration, which Set would skip
+func putExpiredDiskEntry(t *testing.T, client *DiskCache, key string) {
+	t.Helper()
+	storedAt := time.Now().Add(-time.Hour)
+	val, err := encodeDiskEntry(&Entry{StoredAt: storedAt, IsIPAddressNear: true}, storedAt.Add(client.diskOptions.TTL))
+	assert.NoError(t, err)
+	err = client.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskBucket).Put([]byte(key), val)
+	})
+	assert.NoError(t, err)
+}
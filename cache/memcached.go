@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	// memcachedMaxRelativeExpiration is the longest expiration memcached treats as relative, longer values are unix timestamps
+	memcachedMaxRelativeExpiration = 30 * 24 * time.Hour
+	memcachedMaxKeyLength          = 250
+	memcachedRingPointsPerServer   = 160
+)
+
+// MemcachedCache is used to store/fetch ip proximity from memcached.
+// Keys are spread across servers with consistent hashing, so adding or removing a server
+// only moves the keys it owns.
+type MemcachedCache struct {
+	memcachedClient  *memcache.Client
+	memcachedOptions *MemcachedOptions
+	counters         counters
+}
+
+// MemcachedOptions holds memcached configuration parameters.
+type MemcachedOptions struct {
+	// KeyPrefix is prepended to every key
+	KeyPrefix string
+	// Servers are host:port addresses or unix socket paths.
+	// A server listed more than once gets a proportionally larger share of keys.
+	Servers []string
+	// Timeout for socket reads and writes. Defaults to the client default of 500ms.
+	Timeout  time.Duration
+	TTL      time.Duration
+	SoftTTL  time.Duration
+	StaleTTL time.Duration
+}
+
+// NewMemcachedCache provides a new memcached cache client.
+// Server addresses are resolved but not connected to.
+func NewMemcachedCache(memcachedOpts *MemcachedOptions) (*MemcachedCache, error) {
+	ring, err := newHashRing(memcachedOpts.Servers)
+	if err != nil {
+		return nil, err
+	}
+
+	client := memcache.NewFromSelector(ring)
+	if memcachedOpts.Timeout > 0 {
+		client.Timeout = memcachedOpts.Timeout
+	}
+
+	return &MemcachedCache{
+		memcachedClient:  client,
+		memcachedOptions: memcachedOpts,
+	}, nil
+}
+
+// Get gets value from memcached.
+func (m *MemcachedCache) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	item, err := m.memcachedClient.Get(m.key(key))
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			m.counters.lookup(false)
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	m.counters.lookup(true)
+
+	entry := &Entry{}
+	if err := json.Unmarshal(item.Value, entry); err != nil {
+		return nil, false, err
+	}
+	entry.Expired = isExpired(entry.StoredAt, m.memcachedOptions.TTL)
+	entry.Stale = !entry.Expired && isExpired(entry.StoredAt, m.memcachedOptions.SoftTTL)
+
+	return entry, true, nil
+}
+
+// Set sets k/v in memcached.
+func (m *MemcachedCache) Set(ctx context.Context, key string, value *Entry) error {
+	entry, age := stamp(value)
+	ttl, ok := retention(age, m.memcachedOptions.TTL, m.memcachedOptions.StaleTTL)
+	if !ok {
+		return nil
+	}
+
+	val, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return m.memcachedClient.Set(&memcache.Item{
+		Key:        m.key(key),
+		Value:      val,
+		Expiration: memcachedExpiration(ttl, time.Now()),
+	})
+}
+
+// Delete removes k from memcached.
+func (m *MemcachedCache) Delete(ctx context.Context, key string) error {
+	err := m.memcachedClient.Delete(m.key(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	if err == nil {
+		m.counters.evicted(1)
+	}
+	return err
+}
+
+// Flush isn't supported by memcached. It can only flush whole servers, which may hold data of other applications.
+func (m *MemcachedCache) Flush(ctx context.Context) error {
+	return ErrNotSupported
+}
+
+// Len isn't supported by memcached.
+func (m *MemcachedCache) Len(ctx context.Context) (int, error) {
+	return 0, ErrNotSupported
+}
+
+// Range isn't supported by memcached.
+func (m *MemcachedCache) Range(ctx context.Context, fn func(key string, entry *Entry) bool) error {
+	return ErrNotSupported
+}
+
+// Stats returns the memcached cache usage counters.
+func (m *MemcachedCache) Stats() Stats {
+	return m.counters.stats()
+}
+
+// Close closes idle connections to memcached.
+func (m *MemcachedCache) Close() error {
+	return m.memcachedClient.Close()
+}
+
+// key prefixes k and hashes it if memcached wouldn't accept it.
+// Memcached keys can't contain whitespace or control characters and are limited to 250 bytes.
+func (m *MemcachedCache) key(k string) string {
+	key := m.memcachedOptions.KeyPrefix + k
+	if isLegalMemcachedKey(key) {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(k))
+	hashed := "sha256:" + hex.EncodeToString(sum[:])
+	if prefixed := m.memcachedOptions.KeyPrefix + hashed; isLegalMemcachedKey(prefixed) {
+		return prefixed
+	}
+	return hashed
+}
+
+// isLegalMemcachedKey mirrors the key validation done by the memcached text protocol
+func isLegalMemcachedKey(key string) bool {
+	if len(key) == 0 || len(key) > memcachedMaxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// memcachedExpiration converts a TTL to memcached's expiration.
+// 0 never expires, TTLs over 30 days must be sent as a unix timestamp
+// and anything shorter than a second is rounded up since 0 would never expire.
+func memcachedExpiration(ttl time.Duration, now time.Time) int32 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > memcachedMaxRelativeExpiration {
+		return int32(now.Add(ttl).Unix())
+	}
+	return int32((ttl + time.Second - 1) / time.Second)
+}
+
+// hashRing is a ketama style consistent hashing memcache.ServerSelector
+type hashRing struct {
+	addrs  []net.Addr
+	points []ringPoint
+}
+
+type ringPoint struct {
+	addr net.Addr
+	hash uint32
+}
+
+// newHashRing resolves the servers and places each on the ring memcachedRingPointsPerServer times
+func newHashRing(servers []string) (*hashRing, error) {
+	ring := &hashRing{}
+	// listings counts how many times each server was listed.
+	// Points only depend on the server and its listing, so other servers can be added or removed without moving them.
+	listings := map[string]int{}
+
+	for _, server := range servers {
+		addr, err := resolveMemcachedServer(server)
+		if err != nil {
+			return nil, err
+		}
+
+		listing := listings[server]
+		listings[server]++
+		if listing == 0 {
+			ring.addrs = append(ring.addrs, addr)
+		}
+
+		// Each listing of a server adds more points, giving it more weight
+		for p := 0; p < memcachedRingPointsPerServer/4; p++ {
+			sum := md5.Sum([]byte(server + "-" + strconv.Itoa(listing) + "-" + strconv.Itoa(p)))
+			for h := 0; h < 4; h++ {
+				ring.points = append(ring.points, ringPoint{
+					hash: binary.LittleEndian.Uint32(sum[h*4:]),
+					addr: addr,
+				})
+			}
+		}
+	}
+
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+
+	return ring, nil
+}
+
+// resolveMemcachedServer resolves a host:port or unix socket path
+func resolveMemcachedServer(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		return net.ResolveUnixAddr("unix", server)
+	}
+	return net.ResolveTCPAddr("tcp", server)
+}
+
+// PickServer returns the server owning the first point at or after the key's hash
+func (r *hashRing) PickServer(key string) (net.Addr, error) {
+	if len(r.points) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+
+	sum := md5.Sum([]byte(key))
+	hash := binary.LittleEndian.Uint32(sum[:4])
+
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].addr, nil
+}
+
+// Each calls f for each server
+func (r *hashRing) Each(f func(net.Addr) error) error {
+	for _, addr := range r.addrs {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
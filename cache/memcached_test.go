@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMemcached is an in-process server speaking enough of the memcached text protocol for the client
+type fakeMemcached struct {
+	listener net.Listener
+	items    map[string]fakeMemcachedItem
+	mu       sync.Mutex
+}
+
+type fakeMemcachedItem struct {
+	expiresAt time.Time
+	value     []byte
+	flags     uint32
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := &fakeMemcached{
+		listener: listener,
+		items:    map[string]fakeMemcachedItem{},
+	}
+	go f.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return f
+}
+
+func (f *fakeMemcached) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeMemcached) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.items)
+}
+
+func (f *fakeMemcached) item(key string) (fakeMemcachedItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[key]
+	return item, ok
+}
+
+func (f *fakeMemcached) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		f.mu.Lock()
+		switch fields[0] {
+		case "get", "gets":
+			for _, key := range fields[1:] {
+				item, ok := f.items[key]
+				if !ok || (!item.expiresAt.IsZero() && time.Now().After(item.expiresAt)) {
+					continue
+				}
+				fmt.Fprintf(rw, "VALUE %s %d %d 0\r\n%s\r\n", key, item.flags, len(item.value), item.value)
+			}
+			rw.WriteString("END\r\n")
+		case "set":
+			flags, _ := strconv.ParseUint(fields[2], 10, 32)
+			exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+			size, _ := strconv.Atoi(fields[4])
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				f.mu.Unlock()
+				return
+			}
+			f.items[fields[1]] = fakeMemcachedItem{
+				value:     value[:size],
+				flags:     uint32(flags),
+				expiresAt: fakeMemcachedExpiration(exptime),
+			}
+			rw.WriteString("STORED\r\n")
+		case "delete":
+			if _, ok := f.items[fields[1]]; ok {
+				delete(f.items, fields[1])
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		f.mu.Unlock()
+
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// fakeMemcachedExpiration interprets exptime like memcached, relative up to 30 days and a unix timestamp beyond
+func fakeMemcachedExpiration(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime > int64(memcachedMaxRelativeExpiration/time.Second):
+		return time.Unix(exptime, 0)
+	default:
+		return time.Now().Add(time.Duration(exptime) * time.Second)
+	}
+}
+
+func TestNewMemcachedCache(t *testing.T) {
+	tests := []struct {
+		input *MemcachedOptions
+		err   bool
+	}{
+		{
+			input: &MemcachedOptions{
+				Servers: []string{"127.0.0.1:11211"},
+				Timeout: time.Second,
+			},
+		},
+		{
+			input: &MemcachedOptions{
+				Servers: []string{"127.0.0.1:11211", "/tmp/memcached.sock"},
+			},
+		},
+		{
+			input: &MemcachedOptions{
+				Servers: []string{"127.0.0.1:notaport"},
+			},
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		actual, err := NewMemcachedCache(test.input)
+		if test.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.input, actual.memcachedOptions)
+		if test.input.Timeout > 0 {
+			assert.Equal(t, test.input.Timeout, actual.memcachedClient.Timeout)
+		}
+	}
+}
+
+func TestMemcachedGetAndSet(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    bool
+		exists   bool
+		expected bool
+	}{
+		// Ensure key not present
+		{
+			key:    "testkey1",
+			value:  true,
+			exists: false,
+		},
+		// Ensure value was set to true
+		{
+			key:      "testkey2",
+			value:    true,
+			exists:   true,
+			expected: true,
+		},
+		// Ensure value was set to false
+		{
+			key:      "testkey3",
+			value:    false,
+			exists:   true,
+			expected: false,
+		},
+		// Keys memcached would reject are hashed
+		{
+			key:      "fe80::1%eth 0",
+			value:    true,
+			exists:   true,
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		server := newFakeMemcached(t)
+		client, err := NewMemcachedCache(&MemcachedOptions{
+			Servers:   []string{server.addr()},
+			KeyPrefix: "geofence:",
+			TTL:       time.Hour,
+		})
+		assert.NoError(t, err)
+
+		if test.exists {
+			assert.NoError(t, client.Set(context.TODO(), test.key, &Entry{IsIPAddressNear: test.value}))
+		}
+
+		val, exists, err := client.Get(context.TODO(), test.key)
+		assert.NoError(t, err)
+		assert.Equal(t, test.exists, exists)
+		if test.exists {
+			assert.Equal(t, test.expected, val.IsIPAddressNear)
+
+			item, ok := server.item(client.key(test.key))
+			assert.True(t, ok)
+			assert.True(t, strings.HasPrefix(client.key(test.key), "geofence:"))
+			assert.WithinDuration(t, time.Now().Add(time.Hour), item.expiresAt, 2*time.Second)
+		}
+		assert.NoError(t, client.Close())
+	}
+}
+
+func TestMemcachedManagement(t *testing.T) {
+	server := newFakeMemcached(t)
+	client, err := NewMemcachedCache(&MemcachedOptions{Servers: []string{server.addr()}})
+	assert.NoError(t, err)
+	ctx := context.TODO()
+
+	assert.NoError(t, client.Set(ctx, "testkey1", &Entry{IsIPAddressNear: true}))
+	assert.NoError(t, client.Set(ctx, "testkey2", &Entry{IsIPAddressNear: true}))
+
+	assert.NoError(t, client.Delete(ctx, "testkey1"))
+	assert.NoError(t, client.Delete(ctx, "missing"))
+	_, exists, err := client.Get(ctx, "testkey1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, exists, err = client.Get(ctx, "testkey2")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Flushing would empty the whole server
+	assert.ErrorIs(t, client.Flush(ctx), ErrNotSupported)
+	assert.Equal(t, 1, server.len())
+
+	_, err = client.Len(ctx)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	err = client.Range(ctx, func(string, *Entry) bool { return true })
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Evictions: 1}, client.Stats())
+}
+
+func TestMemcachedMultipleServers(t *testing.T) {
+	servers := []*fakeMemcached{newFakeMemcached(t), newFakeMemcached(t), newFakeMemcached(t)}
+	addrs := []string{servers[0].addr(), servers[1].addr(), servers[2].addr()}
+
+	client, err := NewMemcachedCache(&MemcachedOptions{Servers: addrs})
+	assert.NoError(t, err)
+
+	keys := 300
+	for i := 0; i < keys; i++ {
+		assert.NoError(t, client.Set(context.TODO(), fmt.Sprintf("10.0.%d.%d", i/256, i%256), &Entry{IsIPAddressNear: true}))
+	}
+
+	// Every server gets a share of the keys
+	total := 0
+	for _, server := range servers {
+		assert.Greater(t, server.len(), keys/10)
+		total += server.len()
+	}
+	assert.Equal(t, keys, total)
+
+	// Removing a server only moves the keys it owned
+	ring := mustRing(t, addrs)
+	before := map[string]string{}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		picked, err := ring.PickServer(key)
+		assert.NoError(t, err)
+		before[key] = picked.String()
+	}
+	smaller := mustRing(t, addrs[:2])
+	for key, addr := range before {
+		if addr == addrs[2] {
+			continue
+		}
+		picked, err := smaller.PickServer(key)
+		assert.NoError(t, err)
+		assert.Equal(t, addr, picked.String())
+	}
+}
+
+func mustRing(t *testing.T, servers []string) *hashRing {
+	ring, err := newHashRing(servers)
+	assert.NoError(t, err)
+	return ring
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		ttl      time.Duration
+		expected int32
+	}{
+		{
+			ttl:      0,
+			expected: 0,
+		},
+		{
+			ttl:      100 * time.Millisecond,
+			expected: 1,
+		},
+		{
+			ttl:      time.Hour,
+			expected: 3600,
+		},
+		{
+			ttl:      60 * 24 * time.Hour,
+			expected: int32(now.Add(60 * 24 * time.Hour).Unix()),
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, memcachedExpiration(test.ttl, now))
+	}
+}
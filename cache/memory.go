@@ -15,31 +15,95 @@ const (
 type MemoryCache struct {
 	memoryClient  *gocache.Cache
 	memoryOptions *MemoryOptions
+	counters      counters
 }
 
 // MemoryOptions holds in-memory cache configuration parameters.
 type MemoryOptions struct {
 	TTL time.Duration
+	// SoftTTL is how long entries are fresh. Once passed, entries are still used until TTL
+	// but reported as stale so they can be refreshed. Disabled if <= 0.
+	SoftTTL time.Duration
+	// StaleTTL is how long entries are retained after TTL to be served when a lookup fails
+	StaleTTL time.Duration
 }
 
 // NewRedisCache provides a new in-memory cache client.
 func NewMemoryCache(memoryOptions *MemoryOptions) *MemoryCache {
-	return &MemoryCache{
+	m := &MemoryCache{
 		memoryClient:  gocache.New(memoryOptions.TTL, deleteExpiredCacheItemsInternal),
 		memoryOptions: memoryOptions,
 	}
+	// Called for expired and deleted items
+	m.memoryClient.OnEvicted(func(string, interface{}) {
+		m.counters.evicted(1)
+	})
+	return m
 }
 
 // Get gets value from the in-memory cache.
-func (m *MemoryCache) Get(ctx context.Context, key string) (bool, bool, error) {
-	if isIPAddressNear, found := m.memoryClient.Get(key); found {
-		return isIPAddressNear.(bool), found, nil
+func (m *MemoryCache) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	cached, found := m.memoryClient.Get(key)
+	m.counters.lookup(found)
+	if found {
+		return m.entry(cached), found, nil
 	}
-	return false, false, nil
+	return nil, false, nil
+}
+
+// entry copies a stored entry and marks whether it is stale or expired
+func (m *MemoryCache) entry(cached interface{}) *Entry {
+	entry := cached.(Entry)
+	entry.Expired = isExpired(entry.StoredAt, m.memoryOptions.TTL)
+	entry.Stale = !entry.Expired && isExpired(entry.StoredAt, m.memoryOptions.SoftTTL)
+	return &entry
 }
 
 // Set sets k/v in the in-memory cache.
-func (m *MemoryCache) Set(ctx context.Context, key string, value bool) error {
-	m.memoryClient.Set(key, value, m.memoryOptions.TTL)
+func (m *MemoryCache) Set(ctx context.Context, key string, value *Entry) error {
+	entry, age := stamp(value)
+	ttl, ok := retention(age, m.memoryOptions.TTL, m.memoryOptions.StaleTTL)
+	if !ok {
+		return nil
+	}
+	if ttl == 0 {
+		ttl = gocache.NoExpiration
+	}
+
+	m.memoryClient.Set(key, entry, ttl)
 	return nil
 }
+
+// Delete removes k from the in-memory cache.
+func (m *MemoryCache) Delete(ctx context.Context, key string) error {
+	m.memoryClient.Delete(key)
+	return nil
+}
+
+// Flush removes all keys from the in-memory cache.
+func (m *MemoryCache) Flush(ctx context.Context) error {
+	m.counters.evicted(m.memoryClient.ItemCount())
+	m.memoryClient.Flush()
+	return nil
+}
+
+// Len returns the number of keys in the in-memory cache.
+// Expired keys are counted until they are cleaned up.
+func (m *MemoryCache) Len(ctx context.Context) (int, error) {
+	return m.memoryClient.ItemCount(), nil
+}
+
+// Range calls fn for each k/v in the in-memory cache until fn returns false.
+func (m *MemoryCache) Range(ctx context.Context, fn func(key string, entry *Entry) bool) error {
+	for key, item := range m.memoryClient.Items() {
+		if !fn(key, m.entry(item.Object)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Stats returns the in-memory cache usage counters.
+func (m *MemoryCache) Stats() Stats {
+	return m.counters.stats()
+}
@@ -82,12 +82,118 @@ func TestMemoryGetAndSet(t *testing.T) {
 		assert.NotNil(t, client)
 
 		if test.exists {
-			client.Set(context.TODO(), test.input.key, test.input.value)
+			client.Set(context.TODO(), test.input.key, &Entry{IsIPAddressNear: test.input.value})
 		}
 
 		val, exists, err := client.Get(context.TODO(), test.input.key)
-		assert.Equal(t, test.expected, val)
 		assert.Equal(t, test.exists, exists)
 		assert.NoError(t, err)
+		if test.exists {
+			assert.Equal(t, test.expected, val.IsIPAddressNear)
+			assert.False(t, val.StoredAt.IsZero())
+		} else {
+			assert.Nil(t, val)
+		}
+	}
+}
+
+func TestMemoryExpiredAndStale(t *testing.T) {
+	tests := []struct {
+		age     time.Duration
+		exists  bool
+		stale   bool
+		expired bool
+	}{
+		// Fresh entry
+		{
+			age:     time.Second,
+			exists:  true,
+			expired: false,
+		},
+		// Past SoftTTL, still usable
+		{
+			age:     45 * time.Second,
+			exists:  true,
+			stale:   true,
+			expired: false,
+		},
+		// Past TTL but retained for StaleTTL
+		{
+			age:     90 * time.Second,
+			exists:  true,
+			expired: true,
+		},
+		// Past TTL and StaleTTL, never stored
+		{
+			age:    3 * time.Minute,
+			exists: false,
+		},
+	}
+	for _, test := range tests {
+		client := NewMemoryCache(&MemoryOptions{
+			TTL:      time.Minute,
+			SoftTTL:  30 * time.Second,
+			StaleTTL: time.Minute,
+		})
+
+		err := client.Set(context.TODO(), "testkey", &Entry{
+			StoredAt:        time.Now().Add(-test.age),
+			IsIPAddressNear: true,
+		})
+		assert.NoError(t, err)
+
+		val, exists, err := client.Get(context.TODO(), "testkey")
+		assert.NoError(t, err)
+		assert.Equal(t, test.exists, exists)
+		if test.exists {
+			assert.True(t, val.IsIPAddressNear)
+			assert.Equal(t, test.stale, val.Stale)
+			assert.Equal(t, test.expired, val.Expired)
+		}
 	}
 }
+
+func TestMemoryManagement(t *testing.T) {
+	client := NewMemoryCache(&MemoryOptions{})
+	ctx := context.TODO()
+
+	for _, key := range []string{"testkey1", "testkey2", "testkey3"} {
+		assert.NoError(t, client.Set(ctx, key, &Entry{IsIPAddressNear: true}))
+	}
+
+	length, err := client.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, length)
+
+	seen := map[string]bool{}
+	err = client.Range(ctx, func(key string, entry *Entry) bool {
+		seen[key] = entry.IsIPAddressNear
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"testkey1": true, "testkey2": true, "testkey3": true}, seen)
+
+	// Stop early
+	calls := 0
+	err = client.Range(ctx, func(string, *Entry) bool {
+		calls++
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	assert.NoError(t, client.Delete(ctx, "testkey1"))
+	_, exists, err := client.Get(ctx, "testkey1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, exists, err = client.Get(ctx, "testkey2")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, client.Flush(ctx))
+	length, err = client.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, length)
+
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Evictions: 3}, client.Stats())
+}
@@ -2,60 +2,321 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// DefaultRedisKeyPrefix is the KeyPrefix geofence.New uses when RedisOptions doesn't set one
+	DefaultRedisKeyPrefix = "geofence:cache:"
+
+	redisScanCount = 100
+)
+
 // RedisCache is used to store/fetch ip proximity from redis.
 type RedisCache struct {
-	redisClient  *redis.Client
+	redisClient  redis.UniversalClient
 	redisOptions *RedisOptions
+	counters     counters
+	// ownsClient is false when the client was provided by the user and shouldn't be closed
+	ownsClient bool
 }
 
 // RedisOptions holds redis configuration parameters.
 type RedisOptions struct {
-	Addr     string
-	Username string
-	Password string
-	DB       int
-	TTL      time.Duration
+	// Client is an existing client to use instead of connecting with the options below.
+	// It is left open by Close.
+	Client redis.UniversalClient
+	// TLSConfig enables TLS for connections to redis and sentinel
+	TLSConfig *tls.Config
+	Addr      string
+	Username  string
+	Password  string
+	// MasterName is the name of the master monitored by the sentinels listed in Addrs
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+	// KeyPrefix is prepended to every key. Flush, Len and Range only see keys with this prefix
+	// and return ErrNotSupported without one, since they would walk every key of the database.
+	// Use a prefix no other data shares. geofence.New defaults it to DefaultRedisKeyPrefix.
+	KeyPrefix string
+	// Addrs are the seed addresses of a cluster or the sentinel addresses when MasterName is set.
+	// More than one address connects to a cluster.
+	Addrs []string
+	DB    int
+	TTL   time.Duration
+	// SoftTTL is how long entries are fresh. Once passed, entries are still used until TTL
+	// but reported as stale so they can be refreshed. Disabled if <= 0.
+	SoftTTL time.Duration
+	// StaleTTL is how long entries are retained after TTL to be served when a lookup fails
+	StaleTTL time.Duration
+	// Cluster connects to a cluster even if Addrs only contains a single address
+	Cluster bool
 }
 
 // NewRedisCache provides a new redis cache client.
+// Depending on the options, it connects to a single node, a sentinel monitored master or a cluster.
 func NewRedisCache(redisOpts *RedisOptions) *RedisCache {
+	if redisOpts.Client != nil {
+		return &RedisCache{
+			redisClient:  redisOpts.Client,
+			redisOptions: redisOpts,
+		}
+	}
+
 	return &RedisCache{
-		redisClient: redis.NewClient(&redis.Options{
-			Addr:     redisOpts.Addr,
-			Username: redisOpts.Username,
-			Password: redisOpts.Password,
-			DB:       redisOpts.DB,
-		}),
+		redisClient:  newRedisClient(redisOpts),
 		redisOptions: redisOpts,
+		ownsClient:   true,
 	}
 }
 
+// NewRedisClient connects to the redis deployment described by the options, e.g. to share a quota with
+// provider.RedisQuotaCounter. Client and the expiration options are ignored. The caller closes it.
+func NewRedisClient(redisOpts *RedisOptions) redis.UniversalClient {
+	return newRedisClient(redisOpts)
+}
+
+// newRedisClient creates the client matching the deployment described by the options
+func newRedisClient(redisOpts *RedisOptions) redis.UniversalClient {
+	if redisOpts.MasterName == "" && !redisOpts.Cluster && len(redisOpts.Addrs) <= 1 {
+		addr := redisOpts.Addr
+		if addr == "" && len(redisOpts.Addrs) == 1 {
+			addr = redisOpts.Addrs[0]
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:      addr,
+			Username:  redisOpts.Username,
+			Password:  redisOpts.Password,
+			DB:        redisOpts.DB,
+			TLSConfig: redisOpts.TLSConfig,
+		})
+	}
+
+	addrs := redisOpts.Addrs
+	if len(addrs) == 0 && redisOpts.Addr != "" {
+		addrs = []string{redisOpts.Addr}
+	}
+
+	universalOpts := &redis.UniversalOptions{
+		Addrs:            addrs,
+		Username:         redisOpts.Username,
+		Password:         redisOpts.Password,
+		DB:               redisOpts.DB,
+		TLSConfig:        redisOpts.TLSConfig,
+		MasterName:       redisOpts.MasterName,
+		SentinelUsername: redisOpts.SentinelUsername,
+		SentinelPassword: redisOpts.SentinelPassword,
+	}
+
+	// NewUniversalClient only picks a cluster client for multiple addresses
+	if redisOpts.Cluster && redisOpts.MasterName == "" {
+		return redis.NewClusterClient(universalOpts.Cluster())
+	}
+	return redis.NewUniversalClient(universalOpts)
+}
+
+// Close closes the connections to redis.
+// Clients provided through RedisOptions.Client are left open.
+func (r *RedisCache) Close() error {
+	if !r.ownsClient {
+		return nil
+	}
+	return r.redisClient.Close()
+}
+
 // Get gets value from redis.
-func (r *RedisCache) Get(ctx context.Context, key string) (bool, bool, error) {
-	val, err := r.redisClient.Get(ctx, key).Result()
+func (r *RedisCache) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	val, err := r.redisClient.Get(ctx, r.redisOptions.KeyPrefix+key).Result()
 	if err != nil {
 		// If key is not in redis
 		if err == redis.Nil {
-			return false, false, nil
+			r.counters.lookup(false)
+			return nil, false, nil
 		}
-		return false, false, err
+		return nil, false, err
 	}
-	isIPAddressNear, err := strconv.ParseBool(val)
+	r.counters.lookup(true)
+
+	entry, err := decodeRedisEntry(val)
 	if err != nil {
-		return false, false, err
+		return nil, false, err
 	}
+	entry.Expired = isExpired(entry.StoredAt, r.redisOptions.TTL)
+	entry.Stale = !entry.Expired && isExpired(entry.StoredAt, r.redisOptions.SoftTTL)
 
-	return isIPAddressNear, true, nil
+	return entry, true, nil
 }
 
 // Set sets k/v in redis.
-func (r *RedisCache) Set(ctx context.Context, key string, value bool) error {
-	// Redis stores false as 0 for whatever reason, so we'll store as a string and parse it out
-	return r.redisClient.Set(ctx, key, strconv.FormatBool(value), r.redisOptions.TTL).Err()
+func (r *RedisCache) Set(ctx context.Context, key string, value *Entry) error {
+	entry, age := stamp(value)
+	ttl, ok := retention(age, r.redisOptions.TTL, r.redisOptions.StaleTTL)
+	if !ok {
+		return nil
+	}
+
+	val, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return r.redisClient.Set(ctx, r.redisOptions.KeyPrefix+key, string(val), ttl).Err()
+}
+
+// Delete removes k from redis.
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	deleted, err := r.redisClient.Del(ctx, r.redisOptions.KeyPrefix+key).Result()
+	r.counters.evicted(int(deleted))
+	return err
+}
+
+// Flush removes all keys with the configured KeyPrefix from redis
+func (r *RedisCache) Flush(ctx context.Context) error {
+	return r.scan(ctx, func(client redis.Cmdable, keys []string) (bool, error) {
+		// Keys are deleted one by one since a cluster rejects multi-key commands across slots
+		pipe := client.Pipeline()
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		cmds, err := pipe.Exec(ctx)
+		for _, cmd := range cmds {
+			if del, ok := cmd.(*redis.IntCmd); ok {
+				r.counters.evicted(int(del.Val()))
+			}
+		}
+		return true, err
+	})
+}
+
+// Len returns the number of keys with the configured KeyPrefix in redis.
+// Keys are counted with SCAN, so this walks the whole keyspace.
+func (r *RedisCache) Len(ctx context.Context) (int, error) {
+	count := 0
+	err := r.scan(ctx, func(_ redis.Cmdable, keys []string) (bool, error) {
+		count += len(keys)
+		return true, nil
+	})
+	return count, err
+}
+
+// Range calls fn for each k/v with the configured KeyPrefix in redis until fn returns false.
+// Keys passed to fn don't include the prefix.
+func (r *RedisCache) Range(ctx context.Context, fn func(key string, entry *Entry) bool) error {
+	return r.scan(ctx, func(client redis.Cmdable, keys []string) (bool, error) {
+		pipe := client.Pipeline()
+		gets := make([]*redis.StringCmd, len(keys))
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, key)
+		}
+		// Keys that expired since the scan come back as redis.Nil
+		_, err := pipe.Exec(ctx)
+		if err != nil && err != redis.Nil {
+			return false, err
+		}
+
+		for i, get := range gets {
+			if get.Err() != nil {
+				continue
+			}
+			// Other data sharing the prefix isn't a cached result
+			entry, err := decodeRedisEntry(get.Val())
+			if err != nil {
+				continue
+			}
+			entry.Expired = isExpired(entry.StoredAt, r.redisOptions.TTL)
+			entry.Stale = !entry.Expired && isExpired(entry.StoredAt, r.redisOptions.SoftTTL)
+			if !fn(strings.TrimPrefix(keys[i], r.redisOptions.KeyPrefix), entry) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// Stats returns the redis cache usage counters.
+func (r *RedisCache) Stats() Stats {
+	return r.counters.stats()
+}
+
+// scan calls fn with batches of keys matching the configured KeyPrefix until fn returns false.
+// On a cluster, every master is scanned and fn receives the client of the node owning the keys.
+// Without a KeyPrefix it returns ErrNotSupported rather than walking keys that may not belong to the cache.
+func (r *RedisCache) scan(ctx context.Context, fn func(client redis.Cmdable, keys []string) (bool, error)) error {
+	if r.redisOptions.KeyPrefix == "" {
+		return fmt.Errorf("%w: redis without KeyPrefix", ErrNotSupported)
+	}
+	match := escapeGlob(r.redisOptions.KeyPrefix) + "*"
+
+	// Cluster nodes are scanned concurrently, so calls to fn are serialized
+	var mu sync.Mutex
+	stopped := false
+
+	scanNode := func(ctx context.Context, client redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, match, redisScanCount).Result()
+			if err != nil {
+				return err
+			}
+
+			if len(keys) > 0 {
+				mu.Lock()
+				more := !stopped
+				if more {
+					more, err = fn(client, keys)
+					stopped = !more
+				}
+				mu.Unlock()
+				if err != nil || !more {
+					return err
+				}
+			}
+
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	if cluster, ok := r.redisClient.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scanNode(ctx, client)
+		})
+	}
+	return scanNode(ctx, r.redisClient)
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as a pattern
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// decodeRedisEntry parses a stored entry.
+// Older versions stored the bare proximity as "true"/"false", which is still accepted.
+func decodeRedisEntry(val string) (*Entry, error) {
+	if isIPAddressNear, err := strconv.ParseBool(val); err == nil {
+		return &Entry{IsIPAddressNear: isIPAddressNear}, nil
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal([]byte(val), entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
 }
@@ -2,11 +2,14 @@ package cache
 
 import (
 	"context"
-	"strconv"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,7 +33,7 @@ func TestNewRedisCache(t *testing.T) {
 	}
 	for _, test := range tests {
 		actual := NewRedisCache(test.input)
-		actualRedisClientOpts := actual.redisClient.Options()
+		actualRedisClientOpts := actual.redisClient.(*redis.Client).Options()
 		assert.NotNil(t, actual)
 
 		assert.Equal(t, actual.redisOptions, actual.redisOptions)
@@ -39,8 +42,77 @@ func TestNewRedisCache(t *testing.T) {
 		assert.Equal(t, actualRedisClientOpts.Username, test.input.Username)
 		assert.Equal(t, actualRedisClientOpts.Password, test.input.Password)
 		assert.Equal(t, actualRedisClientOpts.DB, test.input.DB)
+		assert.True(t, actual.ownsClient)
 	}
 }
+
+func TestNewRedisCacheDeployments(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	tests := []struct {
+		input    *RedisOptions
+		expected redis.UniversalClient
+	}{
+		// Single address in Addrs
+		{
+			input: &RedisOptions{
+				Addrs:     []string{"localhost:6380"},
+				TLSConfig: tlsConfig,
+			},
+			expected: &redis.Client{},
+		},
+		// Multiple addresses form a cluster
+		{
+			input: &RedisOptions{
+				Addrs:     []string{"localhost:7000", "localhost:7001"},
+				TLSConfig: tlsConfig,
+			},
+			expected: &redis.ClusterClient{},
+		},
+		// Cluster with a single seed address
+		{
+			input: &RedisOptions{
+				Addr:    "localhost:7000",
+				Cluster: true,
+			},
+			expected: &redis.ClusterClient{},
+		},
+		// Sentinel
+		{
+			input: &RedisOptions{
+				Addrs:      []string{"localhost:26379", "localhost:26380"},
+				MasterName: "mymaster",
+				TLSConfig:  tlsConfig,
+			},
+			expected: &redis.Client{},
+		},
+	}
+	for _, test := range tests {
+		actual := NewRedisCache(test.input)
+		assert.IsType(t, test.expected, actual.redisClient)
+
+		switch client := actual.redisClient.(type) {
+		case *redis.Client:
+			assert.Equal(t, test.input.TLSConfig, client.Options().TLSConfig)
+		case *redis.ClusterClient:
+			assert.Equal(t, test.input.TLSConfig, client.Options().TLSConfig)
+		}
+		assert.NoError(t, actual.Close())
+	}
+}
+
+func TestRedisCloseProvidedClient(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer client.Close()
+
+	actual := NewRedisCache(&RedisOptions{Client: client})
+	assert.Equal(t, client, actual.redisClient)
+	assert.NoError(t, actual.Close())
+
+	// Client provided by the user is still open
+	err := client.Ping(context.TODO()).Err()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, redis.ErrClosed)
+}
 func TestRedisGet(t *testing.T) {
 	tests := []struct {
 		key   string
@@ -75,14 +147,14 @@ func TestRedisGet(t *testing.T) {
 
 		// Overide real client with mock client
 		client.redisClient = db
-		setVal := strconv.FormatBool(test.value)
+		setVal, _ := json.Marshal(&Entry{StoredAt: time.Now(), IsIPAddressNear: test.value})
 
 		// Set key if expected to be present
 		if test.exists {
-			mock.ExpectSet(test.key, setVal, ttl).SetVal(setVal)
-			mock.ExpectGet(test.key).SetVal(setVal)
+			mock.Regexp().ExpectSet(test.key, fmt.Sprintf(`"is_ip_address_near":%t`, test.value), ttl).SetVal("OK")
+			mock.ExpectGet(test.key).SetVal(string(setVal))
 
-			err := client.Set(context.TODO(), test.key, test.value)
+			err := client.Set(context.TODO(), test.key, &Entry{IsIPAddressNear: test.value})
 			assert.NoError(t, err)
 		} else {
 			mock.ExpectGet(test.key).RedisNil()
@@ -91,8 +163,40 @@ func TestRedisGet(t *testing.T) {
 		val, exists, err := client.Get(context.TODO(), test.key)
 
 		assert.NoError(t, err)
-		assert.Equal(t, test.value, val)
 		assert.Equal(t, test.exists, exists)
+		if test.exists {
+			assert.Equal(t, test.value, val.IsIPAddressNear)
+			assert.False(t, val.Expired)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestRedisGetLegacyValue(t *testing.T) {
+	tests := []struct {
+		stored   string
+		expected bool
+	}{
+		{
+			stored:   "true",
+			expected: true,
+		},
+		{
+			stored:   "false",
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		client := NewRedisCache(&RedisOptions{TTL: time.Second * 5})
+		db, mock := redismock.NewClientMock()
+		client.redisClient = db
+
+		mock.ExpectGet("testkey").SetVal(test.stored)
+
+		val, exists, err := client.Get(context.TODO(), "testkey")
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, test.expected, val.IsIPAddressNear)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	}
 }
@@ -123,12 +227,123 @@ func TestRedisSet(t *testing.T) {
 
 		// Overide real client with mock client
 		client.redisClient = db
-		setVal := strconv.FormatBool(test.value)
 
-		mock.ExpectSet(test.key, setVal, ttl).SetVal(setVal)
+		mock.Regexp().ExpectSet(test.key, fmt.Sprintf(`"is_ip_address_near":%t`, test.value), ttl).SetVal("OK")
 
-		err := client.Set(context.TODO(), test.key, test.value)
+		err := client.Set(context.TODO(), test.key, &Entry{IsIPAddressNear: test.value})
 		assert.NoError(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	}
 }
+
+func TestRedisManagement(t *testing.T) {
+	ctx := context.TODO()
+	client := NewRedisCache(&RedisOptions{KeyPrefix: "geofence:"})
+	db, mock := redismock.NewClientMock()
+	client.redisClient = db
+
+	storedVal, _ := json.Marshal(&Entry{StoredAt: time.Now(), IsIPAddressNear: true})
+	keys := []string{"geofence:testkey1", "geofence:testkey2"}
+
+	// Delete
+	mock.ExpectDel("geofence:testkey1").SetVal(1)
+	assert.NoError(t, client.Delete(ctx, "testkey1"))
+
+	// Len
+	mock.ExpectScan(0, "geofence:*", redisScanCount).SetVal(keys[:1], 7)
+	mock.ExpectScan(7, "geofence:*", redisScanCount).SetVal(keys[1:], 0)
+	length, err := client.Len(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, length)
+
+	// Range strips the prefix and skips keys that expired since the scan
+	mock.ExpectScan(0, "geofence:*", redisScanCount).SetVal(keys, 0)
+	mock.ExpectGet(keys[0]).SetVal(string(storedVal))
+	mock.ExpectGet(keys[1]).RedisNil()
+	seen := map[string]bool{}
+	err = client.Range(ctx, func(key string, entry *Entry) bool {
+		seen[key] = entry.IsIPAddressNear
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"testkey1": true}, seen)
+
+	// Flush
+	mock.ExpectScan(0, "geofence:*", redisScanCount).SetVal(keys, 0)
+	mock.ExpectDel(keys[0]).SetVal(1)
+	mock.ExpectDel(keys[1]).SetVal(1)
+	assert.NoError(t, client.Flush(ctx))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, Stats{Evictions: 3}, client.Stats())
+
+	// Hits and misses
+	mock.ExpectGet("geofence:testkey1").SetVal(string(storedVal))
+	mock.ExpectGet("geofence:testkey2").RedisNil()
+	_, _, err = client.Get(ctx, "testkey1")
+	assert.NoError(t, err)
+	_, _, err = client.Get(ctx, "testkey2")
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Evictions: 3}, client.Stats())
+}
+
+func TestRedisManagementWithoutKeyPrefix(t *testing.T) {
+	ctx := context.TODO()
+	client := NewRedisCache(&RedisOptions{})
+	db, mock := redismock.NewClientMock()
+	client.redisClient = db
+
+	// Nothing is scanned, let alone deleted
+	assert.ErrorIs(t, client.Flush(ctx), ErrNotSupported)
+	_, err := client.Len(ctx)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	err = client.Range(ctx, func(key string, entry *Entry) bool { return true })
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Single keys still work
+	mock.ExpectDel("testkey").SetVal(1)
+	assert.NoError(t, client.Delete(ctx, "testkey"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRedisRangeSkipsOtherData(t *testing.T) {
+	ctx := context.TODO()
+	client := NewRedisCache(&RedisOptions{KeyPrefix: "geofence:"})
+	db, mock := redismock.NewClientMock()
+	client.redisClient = db
+
+	storedVal, _ := json.Marshal(&Entry{StoredAt: time.Now(), IsIPAddressNear: true})
+	keys := []string{"geofence:quota:2026-10", "geofence:8.8.8.8"}
+	mock.ExpectScan(0, "geofence:*", redisScanCount).SetVal(keys, 0)
+	mock.ExpectGet(keys[0]).SetVal("42")
+	mock.ExpectGet(keys[1]).SetVal(string(storedVal))
+
+	seen := map[string]bool{}
+	err := client.Range(ctx, func(key string, entry *Entry) bool {
+		seen[key] = entry.IsIPAddressNear
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"8.8.8.8": true}, seen)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEscapeGlob(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "geofence:",
+			expected: "geofence:",
+		},
+		{
+			input:    "geo*[fence]?\\",
+			expected: "geo\\*\\[fence\\]\\?\\\\",
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, escapeGlob(test.input))
+	}
+}
@@ -0,0 +1,262 @@
+package geofence
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	centerInitialBackoff = time.Second
+	centerMaxBackoff     = time.Minute
+)
+
+// CenterRefreshOptions looks up a center located from Config.IPAddress again while the geofence runs,
+// for hosts whose public ip address moves. Ticks are skipped while the rules in effect set a Center or Place.
+type CenterRefreshOptions struct {
+	// OnCenterMove is called after the center moved by more than MinDistance
+	OnCenterMove func(from, to Coordinates)
+	// Interval between lookups of the center. Disabled if <= 0.
+	Interval time.Duration
+	// NetworkCheckInterval is how often the addresses of the local network interfaces are checked,
+	// the center is looked up when they changed. Disabled if <= 0.
+	NetworkCheckInterval time.Duration
+	// MinDistance the center must move to be updated, in the Unit of the rules in effect. Smaller moves are ignored.
+	MinDistance float64
+}
+
+// center tracks looking up the center of the geofence
+type center struct {
+	retryAt time.Time
+	err     error
+	// stop ends the refresher started by startCenterRefresh
+	stop chan struct{}
+	// interfaceAddrs lists the addresses of the local network interfaces
+	interfaceAddrs func() ([]net.Addr, error)
+	// newTicker returns the ticks of the refresher every d and a func stopping them
+	newTicker func(d time.Duration) (<-chan time.Time, func())
+	// ticked is called once the refresher handled a tick, if set
+	ticked  func()
+	done    sync.WaitGroup
+	backoff time.Duration
+	// coordinatesMu guards Geofence.Latitude and Geofence.Longitude once the center can move
+	coordinatesMu sync.RWMutex
+	stopOnce      sync.Once
+	mu            sync.Mutex
+	// located is set atomically once Latitude and Longitude are known
+	located uint32
+}
+
+// Center returns the coordinates of the center of the geofence
+func (g *Geofence) Center() Coordinates {
+	g.center.coordinatesMu.RLock()
+	defer g.center.coordinatesMu.RUnlock()
+	return Coordinates{Latitude: g.Latitude, Longitude: g.Longitude}
+}
+
+// setCenter sets the coordinates of the geofence and the fingerprint of the rules in effect
+func (g *Geofence) setCenter(coordinates Coordinates) {
+	g.reloading.Lock()
+	defer g.reloading.Unlock()
+
+	g.moveCenter(coordinates)
+	g.storeRules(g.currentRules())
+}
+
+// moveCenter sets the coordinates of the geofence. The caller must hold g.reloading and store the rules again.
+func (g *Geofence) moveCenter(coordinates Coordinates) {
+	g.center.coordinatesMu.Lock()
+	g.Latitude = coordinates.Latitude
+	g.Longitude = coordinates.Longitude
+	g.center.coordinatesMu.Unlock()
+	atomic.StoreUint32(&g.center.located, 1)
+}
+
+// ensureCenter locates the center on first use with Config.LazyCenter, otherwise New already did
+func (g *Geofence) ensureCenter() error {
+	if !g.Config.LazyCenter {
+		return nil
+	}
+	return g.locateCenter()
+}
+
+// locateCenter looks up the center of the geofence from Config.IPAddress unless it's already known.
+// After a failure the lookup isn't attempted again until the backoff passed, the failure is returned meanwhile.
+func (g *Geofence) locateCenter() error {
+	if atomic.LoadUint32(&g.center.located) == 1 {
+		return nil
+	}
+
+	g.center.mu.Lock()
+	defer g.center.mu.Unlock()
+
+	// Another caller may have located the center while we waited
+	if atomic.LoadUint32(&g.center.located) == 1 {
+		return nil
+	}
+	if time.Now().Before(g.center.retryAt) {
+		return g.center.err
+	}
+
+	// Get current location of specified IP address
+	// If empty string, use public IP of device running this
+	// or use location of the specified IP
+	location, err := g.provider.Lookup(g.ctx, g.Config.IPAddress)
+	if err != nil {
+		g.center.backoff *= 2
+		if g.center.backoff < centerInitialBackoff {
+			g.center.backoff = centerInitialBackoff
+		}
+		if g.center.backoff > centerMaxBackoff {
+			g.center.backoff = centerMaxBackoff
+		}
+		g.center.err = err
+		g.center.retryAt = time.Now().Add(g.center.backoff)
+		return err
+	}
+
+	g.center.err = nil
+	g.setCenter(Coordinates{Latitude: location.Latitude, Longitude: location.Longitude})
+	return nil
+}
+
+// RelocateCenter looks up the center from Config.IPAddress again, e.g. when the application knows the network changed.
+// If the center moved by more than CenterRefreshOptions.MinDistance, cached results decided against the old center
+// are ignored from then on and OnCenterMove is called. It returns whether the center moved.
+// Centers set with Center or Place of the rules never move.
+func (g *Geofence) RelocateCenter() (bool, error) {
+	if g.currentRules().fixedCenter() {
+		return false, nil
+	}
+
+	g.center.mu.Lock()
+	defer g.center.mu.Unlock()
+
+	location, err := g.provider.Lookup(g.ctx, g.Config.IPAddress)
+	if err != nil {
+		return false, err
+	}
+	to := Coordinates{Latitude: location.Latitude, Longitude: location.Longitude}
+
+	// A lazy center that was never located hasn't moved
+	if atomic.LoadUint32(&g.center.located) == 0 {
+		g.center.err = nil
+		g.setCenter(to)
+		return false, nil
+	}
+
+	var opts CenterRefreshOptions
+	if g.Config.CenterRefresh != nil {
+		opts = *g.Config.CenterRefresh
+	}
+
+	rules := g.currentRules()
+	from := g.Center()
+	distance := from.Distance(to, rules.DistanceFormula, rules.Unit)
+	if distance <= opts.MinDistance || from == to {
+		return false, nil
+	}
+
+	g.setCenter(to)
+	if opts.OnCenterMove != nil {
+		opts.OnCenterMove(from, to)
+	}
+	return true, nil
+}
+
+// startCenterRefresh starts the refresher of Config.CenterRefresh. It also runs while the center is fixed,
+// since a Reload may remove the Center or Place from the rules.
+func (g *Geofence) startCenterRefresh() {
+	opts := g.Config.CenterRefresh
+	if opts == nil {
+		return
+	}
+	if opts.Interval <= 0 && opts.NetworkCheckInterval <= 0 {
+		return
+	}
+
+	g.center.stop = make(chan struct{})
+	g.center.done.Add(1)
+	go g.refreshCenter(opts)
+}
+
+// refreshCenter looks up the center on every tick until stopCenterRefresh is called
+func (g *Geofence) refreshCenter(opts *CenterRefreshOptions) {
+	defer g.center.done.Done()
+
+	var interval, networkCheck <-chan time.Time
+	if opts.Interval > 0 {
+		ticks, stop := g.center.newTicker(opts.Interval)
+		defer stop()
+		interval = ticks
+	}
+	if opts.NetworkCheckInterval > 0 {
+		ticks, stop := g.center.newTicker(opts.NetworkCheckInterval)
+		defer stop()
+		networkCheck = ticks
+	}
+
+	network := g.networkFingerprint()
+	for {
+		select {
+		case <-g.center.stop:
+			return
+		case <-interval:
+			// Failures keep the current center until the next attempt
+			_, _ = g.RelocateCenter()
+		case <-networkCheck:
+			network = g.checkNetwork(network)
+		}
+		if g.center.ticked != nil {
+			g.center.ticked()
+		}
+	}
+}
+
+// checkNetwork looks up the center if the network changed since network and returns the network the center is located for
+func (g *Geofence) checkNetwork(network string) string {
+	if g.currentRules().fixedCenter() {
+		return network
+	}
+	current := g.networkFingerprint()
+	if current == network {
+		return network
+	}
+	if _, err := g.RelocateCenter(); err != nil {
+		return network
+	}
+	return current
+}
+
+// stopCenterRefresh stops the refresher and waits for it to return
+func (g *Geofence) stopCenterRefresh() {
+	g.center.stopOnce.Do(func() {
+		if g.center.stop != nil {
+			close(g.center.stop)
+		}
+	})
+	g.center.done.Wait()
+}
+
+// networkFingerprint summarizes the addresses of the local network interfaces so changes can be detected
+func (g *Geofence) networkFingerprint() string {
+	addrs, err := g.center.interfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	fingerprint := make([]string, len(addrs))
+	for i, addr := range addrs {
+		fingerprint[i] = addr.String()
+	}
+	sort.Strings(fingerprint)
+	return strings.Join(fingerprint, ",")
+}
+
+// newTicker returns the ticks of a time.Ticker
+func newTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
@@ -0,0 +1,205 @@
+package geofence
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// movingHost is a provider whose own location can be moved, other addresses are in Kansas
+type movingHost struct {
+	location provider.Location
+	mu       sync.Mutex
+}
+
+func (h *movingHost) move(latitude, longitude float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.location.Latitude, h.location.Longitude = latitude, longitude
+}
+
+func (h *movingHost) Lookup(ctx context.Context, ipAddress string) (*provider.Location, error) {
+	if ipAddress != "" {
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	location := h.location
+	return &location, nil
+}
+
+// manualTicks replaces the tickers of the center refresher with ticks sent by the test
+type manualTicks struct {
+	ticks   chan time.Time
+	handled chan struct{}
+}
+
+// tick sends a tick and returns once the refresher handled it
+func (m *manualTicks) tick() {
+	m.ticks <- time.Now()
+	<-m.handled
+}
+
+// startManualCenterRefresh starts refreshing the center of geofence with opts on ticks sent by the test
+func startManualCenterRefresh(geofence *Geofence, opts *CenterRefreshOptions) *manualTicks {
+	m := &manualTicks{
+		ticks:   make(chan time.Time),
+		handled: make(chan struct{}),
+	}
+	geofence.center.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		return m.ticks, func() {}
+	}
+	geofence.center.ticked = func() { m.handled <- struct{}{} }
+	geofence.Config.CenterRefresh = opts
+	geofence.startCenterRefresh()
+	return m
+}
+
+func TestGeofenceCenterRefresh(t *testing.T) {
+	host := &movingHost{location: provider.Location{Latitude: 37.751, Longitude: -97.822}}
+	moves := make(chan [2]Coordinates, 1)
+
+	geofence, err := New(&Config{
+		Provider: host,
+		Radius:   1,
+		CacheTTL: time.Hour,
+	})
+	assert.NoError(t, err)
+	defer geofence.Close()
+	ticks := startManualCenterRefresh(geofence, &CenterRefreshOptions{
+		Interval:    time.Hour,
+		MinDistance: 1,
+		OnCenterMove: func(from, to Coordinates) {
+			moves <- [2]Coordinates{from, to}
+		},
+	})
+
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+
+	// Moves within MinDistance are ignored
+	host.move(37.7511, -97.8221)
+	ticks.tick()
+	assert.Equal(t, Coordinates{Latitude: 37.751, Longitude: -97.822}, geofence.Center())
+
+	host.move(48.8566, 2.3522)
+	ticks.tick()
+	select {
+	case move := <-moves:
+		assert.Equal(t, Coordinates{Latitude: 37.751, Longitude: -97.822}, move[0])
+		assert.Equal(t, Coordinates{Latitude: 48.8566, Longitude: 2.3522}, move[1])
+	default:
+		t.Fatal("center didn't move")
+	}
+	assert.Equal(t, Coordinates{Latitude: 48.8566, Longitude: 2.3522}, geofence.Center())
+
+	// Results decided against the old center are looked up again
+	decision, err = geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, SourceLookup, decision.Source)
+	assert.False(t, decision.IsIPAddressNear)
+}
+
+func TestGeofenceCenterRefreshNetworkChange(t *testing.T) {
+	host := &movingHost{location: provider.Location{Latitude: 37.751, Longitude: -97.822}}
+	moved := make(chan struct{}, 1)
+
+	geofence, err := New(&Config{
+		Provider: host,
+		Radius:   1,
+	})
+	assert.NoError(t, err)
+	defer geofence.Close()
+
+	var mu sync.Mutex
+	addrs := []net.Addr{&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)}}
+	geofence.center.interfaceAddrs = func() ([]net.Addr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return addrs, nil
+	}
+	ticks := startManualCenterRefresh(geofence, &CenterRefreshOptions{
+		NetworkCheckInterval: time.Hour,
+		OnCenterMove: func(from, to Coordinates) {
+			moved <- struct{}{}
+		},
+	})
+
+	// The center isn't looked up again while the network stays the same
+	host.move(48.8566, 2.3522)
+	ticks.tick()
+	assert.Equal(t, Coordinates{Latitude: 37.751, Longitude: -97.822}, geofence.Center())
+
+	mu.Lock()
+	addrs = []net.Addr{&net.IPNet{IP: net.ParseIP("10.0.0.10"), Mask: net.CIDRMask(8, 32)}}
+	mu.Unlock()
+	ticks.tick()
+	select {
+	case <-moved:
+	default:
+		t.Fatal("center didn't move")
+	}
+	assert.Equal(t, Coordinates{Latitude: 48.8566, Longitude: 2.3522}, geofence.Center())
+}
+
+func TestGeofenceCenterRefreshReload(t *testing.T) {
+	host := &movingHost{location: provider.Location{Latitude: 48.8566, Longitude: 2.3522}}
+	kansas := Coordinates{Latitude: 37.751, Longitude: -97.822}
+
+	geofence, err := New(&Config{
+		Provider: host,
+		Radius:   1,
+		Center:   &kansas,
+	})
+	assert.NoError(t, err)
+	defer geofence.Close()
+	ticks := startManualCenterRefresh(geofence, &CenterRefreshOptions{Interval: time.Hour})
+
+	// A fixed center isn't looked up
+	ticks.tick()
+	assert.Equal(t, kansas, geofence.Center())
+
+	// Reloading without the fixed center looks it up on the next tick
+	rules := geofence.Rules()
+	rules.Center = nil
+	assert.NoError(t, geofence.Reload(rules))
+	ticks.tick()
+	assert.Equal(t, Coordinates{Latitude: 48.8566, Longitude: 2.3522}, geofence.Center())
+
+	// Reloading with a fixed center stops looking it up
+	rules.Center = &kansas
+	assert.NoError(t, geofence.Reload(rules))
+	host.move(35.6762, 139.6503)
+	ticks.tick()
+	assert.Equal(t, kansas, geofence.Center())
+}
+
+func TestGeofenceRelocateCenter(t *testing.T) {
+	host := &movingHost{location: provider.Location{Latitude: 37.751, Longitude: -97.822}}
+
+	geofence, err := New(&Config{Provider: host, Radius: 1})
+	assert.NoError(t, err)
+
+	moved, err := geofence.RelocateCenter()
+	assert.NoError(t, err)
+	assert.False(t, moved)
+
+	host.move(48.8566, 2.3522)
+	moved, err = geofence.RelocateCenter()
+	assert.NoError(t, err)
+	assert.True(t, moved)
+	assert.Equal(t, Coordinates{Latitude: 48.8566, Longitude: 2.3522}, geofence.Center())
+
+	// Fixed centers never move
+	geofence, err = New(&Config{Provider: host, Place: "Tokyo"})
+	assert.NoError(t, err)
+	moved, err = geofence.RelocateCenter()
+	assert.NoError(t, err)
+	assert.False(t, moved)
+}
@@ -0,0 +1,312 @@
+// Package config builds a geofence from a YAML, JSON or TOML configuration file.
+//
+// String values can reference environment variables with ${NAME} or ${NAME:-default}, $$ is a literal $.
+// References are replaced after parsing, so the value of a variable can't change the structure of the file.
+// Values with references are converted to the number, integer or boolean the schema expects, e.g. radius: ${RADIUS}.
+// Secrets can be read from files with token_file and password_file instead of being written inline.
+// Files are validated against the JSON Schema returned by Schema before anything is set up.
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/circa10a/go-geofence"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	schemaURL = "https://github.com/circa10a/go-geofence/config/schema.json"
+)
+
+// Format is the syntax of a configuration file
+type Format string
+
+const (
+	// FormatYAML is used for .yaml and .yml files
+	FormatYAML Format = "yaml"
+	// FormatJSON is used for .json files
+	FormatJSON Format = "json"
+	// FormatTOML is used for .toml files
+	FormatTOML Format = "toml"
+)
+
+var (
+	// ErrUnknownFormat is returned for files that aren't .yaml, .yml, .json or .toml
+	ErrUnknownFormat = errors.New("unknown configuration format")
+	// ErrMissingEnv is returned when a referenced environment variable isn't set and has no default
+	ErrMissingEnv = errors.New("environment variable not set")
+	// ErrInvalidConfig is returned when a file doesn't match the schema
+	ErrInvalidConfig = errors.New("invalid configuration")
+)
+
+//go:embed schema.json
+var schemaJSON string
+
+var schema = jsonschema.MustCompileString(schemaURL, schemaJSON)
+
+// schemaDocument is the parsed schema, for converting interpolated values to the types it expects
+var schemaDocument = mustParseSchema()
+
+// envPattern matches $$, ${NAME} and ${NAME:-default}
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Schema returns the JSON Schema configuration files are validated against
+func Schema() []byte {
+	return []byte(schemaJSON)
+}
+
+// FormatFromPath returns the format of a file from its extension
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("%w: %s, expected .yaml, .yml, .json or .toml", ErrUnknownFormat, path)
+	}
+}
+
+// Load builds a geofence from a configuration file
+func Load(path string) (*geofence.Geofence, error) {
+	file, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := file.Config()
+	if err != nil {
+		return nil, err
+	}
+	g, err := geofence.New(c)
+	if err != nil {
+		// The geofence would have closed the quota counter
+		if c.RateLimit != nil {
+			if closer, ok := c.RateLimit.QuotaCounter.(io.Closer); ok {
+				_ = closer.Close()
+			}
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+// LoadFile reads and validates a configuration file.
+// Relative paths in the file, such as token_file, are relative to the directory of the file.
+func LoadFile(path string) (*File, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file.dir = filepath.Dir(path)
+	return file, nil
+}
+
+// Parse interpolates environment variables in the string values of data and validates it.
+// Relative paths in the configuration are relative to the working directory.
+func Parse(data []byte, format Format) (*File, error) {
+	var document interface{}
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &document)
+	case FormatJSON:
+		err = json.Unmarshal(data, &document)
+	case FormatTOML:
+		var table map[string]interface{}
+		_, err = toml.Decode(string(data), &table)
+		document = table
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	document = interpolate(document, &missing)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s", ErrMissingEnv, strings.Join(missing, ", "))
+	}
+	document = coerce(document, schemaDocument)
+
+	// Every format is validated and decoded as json so they behave the same
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var instance interface{}
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.UseNumber()
+	if err := decoder.Decode(&instance); err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(instance); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+
+	file := &File{}
+	if err := json.Unmarshal(normalized, file); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+	return file, nil
+}
+
+// interpolated is a string value that referenced environment variables, converted by coerce before validation
+type interpolated string
+
+// interpolate replaces references to environment variables in the string values of a parsed document.
+// Names of variables that aren't set and have no default are appended to missing.
+func interpolate(value interface{}, missing *[]string) interface{} {
+	switch value := value.(type) {
+	case string:
+		if !envPattern.MatchString(value) {
+			return value
+		}
+		return interpolated(envPattern.ReplaceAllStringFunc(value, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+			groups := envPattern.FindStringSubmatch(match)
+			if env, ok := os.LookupEnv(groups[1]); ok {
+				return env
+			}
+			if groups[2] != "" {
+				return groups[3]
+			}
+			*missing = append(*missing, groups[1])
+			return ""
+		}))
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = interpolate(item, missing)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = interpolate(item, missing)
+		}
+	case []map[string]interface{}:
+		for _, item := range value {
+			interpolate(item, missing)
+		}
+	}
+	return value
+}
+
+// coerce converts the interpolated values of a document to the type the schema node expects.
+// Values that don't parse as that type are left as strings for the schema to reject.
+func coerce(value interface{}, node map[string]interface{}) interface{} {
+	node = resolveSchemaRef(node)
+	switch value := value.(type) {
+	case interpolated:
+		s := string(value)
+		switch node["type"] {
+		case "number":
+			if number, err := strconv.ParseFloat(s, 64); err == nil {
+				return number
+			}
+		case "integer":
+			if integer, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return integer
+			}
+		case "boolean":
+			if boolean, err := strconv.ParseBool(s); err == nil {
+				return boolean
+			}
+		}
+		return s
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = coerce(item, propertySchema(node, key))
+		}
+	case []interface{}:
+		items, _ := node["items"].(map[string]interface{})
+		for i, item := range value {
+			value[i] = coerce(item, items)
+		}
+	case []map[string]interface{}:
+		items, _ := node["items"].(map[string]interface{})
+		for _, item := range value {
+			coerce(item, items)
+		}
+	}
+	return value
+}
+
+// propertySchema returns the schema node of the property key of an object node, nil if it's unknown
+func propertySchema(node map[string]interface{}, key string) map[string]interface{} {
+	if properties, ok := node["properties"].(map[string]interface{}); ok {
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			return property
+		}
+	}
+	additional, _ := node["additionalProperties"].(map[string]interface{})
+	return additional
+}
+
+// resolveSchemaRef returns the definition a node refers to with $ref, or the node itself
+func resolveSchemaRef(node map[string]interface{}) map[string]interface{} {
+	ref, ok := node["$ref"].(string)
+	if !ok {
+		return node
+	}
+	defs, _ := schemaDocument["$defs"].(map[string]interface{})
+	def, _ := defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+	return def
+}
+
+// mustParseSchema parses the embedded schema
+func mustParseSchema() map[string]interface{} {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(schemaJSON), &document); err != nil {
+		panic(err)
+	}
+	return document
+}
+
+// Duration is a time.Duration written as a Go duration string, e.g. 300ms or 24h
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON formats the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,370 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence"
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Setenv("GEOFENCE_TOKEN", "fakeApiToken")
+
+	tests := []struct {
+		format Format
+		data   string
+	}{
+		{
+			format: FormatYAML,
+			data: `
+token: ${GEOFENCE_TOKEN}
+place: ${GEOFENCE_PLACE:-Paris, FR}
+radius: 50
+unit: mi
+distance_formula: vincenty
+uncertain_policy: closed
+default_accuracy_radius: 25
+failure_policy: closed
+cache:
+  ttl: 24h
+  negative_ttl: 1m
+retry:
+  max_attempts: 5
+overrides:
+  - cidr: 203.0.113.0/24
+    verdict: allow
+    name: price $$5
+`,
+		},
+		{
+			format: FormatJSON,
+			data: `{
+				"token": "${GEOFENCE_TOKEN}",
+				"place": "${GEOFENCE_PLACE:-Paris, FR}",
+				"radius": 50,
+				"unit": "mi",
+				"distance_formula": "vincenty",
+				"uncertain_policy": "closed",
+				"default_accuracy_radius": 25,
+				"failure_policy": "closed",
+				"cache": {"ttl": "24h", "negative_ttl": "1m"},
+				"retry": {"max_attempts": 5},
+				"overrides": [{"cidr": "203.0.113.0/24", "verdict": "allow", "name": "price $$5"}]
+			}`,
+		},
+		{
+			format: FormatTOML,
+			data: `
+token = "${GEOFENCE_TOKEN}"
+place = "${GEOFENCE_PLACE:-Paris, FR}"
+radius = 50
+unit = "mi"
+distance_formula = "vincenty"
+uncertain_policy = "closed"
+default_accuracy_radius = 25
+failure_policy = "closed"
+
+[cache]
+ttl = "24h"
+negative_ttl = "1m"
+
+[retry]
+max_attempts = 5
+
+[[overrides]]
+cidr = "203.0.113.0/24"
+verdict = "allow"
+name = "price $$5"
+`,
+		},
+	}
+	for _, test := range tests {
+		file, err := Parse([]byte(test.data), test.format)
+		assert.NoError(t, err, test.format)
+
+		c, err := file.Config()
+		assert.NoError(t, err, test.format)
+		assert.Equal(t, "fakeApiToken", c.Token)
+		assert.Equal(t, "Paris, FR", c.Place)
+		assert.Equal(t, 50.0, c.Radius)
+		assert.Equal(t, geofence.UnitMiles, c.Unit)
+		assert.Equal(t, geofence.FormulaVincenty, c.DistanceFormula)
+		assert.Equal(t, geofence.UncertainPolicyClosed, c.UncertainPolicy)
+		assert.Equal(t, 25.0, c.DefaultAccuracyRadius)
+		assert.Equal(t, geofence.FailurePolicyClosed, c.FailurePolicy)
+		assert.Equal(t, 24*time.Hour, c.CacheTTL)
+		assert.Equal(t, time.Minute, c.NegativeCacheTTL)
+		assert.Equal(t, 5, c.RetryPolicy.MaxAttempts)
+		assert.Nil(t, c.Provider)
+		assert.Equal(t, 1, c.Overrides.Len())
+		assert.Equal(t, "price $5", c.Overrides.List()[0].Name)
+	}
+}
+
+func TestParseInterpolation(t *testing.T) {
+	// Values of variables are never parsed, so they can't add or change settings
+	t.Setenv("GEOFENCE_TOKEN", "fakeApiToken\"\nallow_private_ip_addresses: true\nx: \"")
+
+	tests := []struct {
+		format Format
+		data   string
+	}{
+		{
+			format: FormatYAML,
+			data: `
+# token: ${GEOFENCE_UNSET_TOKEN}
+token: "${GEOFENCE_TOKEN}"
+`,
+		},
+		{
+			format: FormatJSON,
+			data:   `{"token": "${GEOFENCE_TOKEN}"}`,
+		},
+		{
+			format: FormatTOML,
+			data: `
+# token = "${GEOFENCE_UNSET_TOKEN}"
+token = "${GEOFENCE_TOKEN}"
+`,
+		},
+	}
+	for _, test := range tests {
+		file, err := Parse([]byte(test.data), test.format)
+		assert.NoError(t, err, test.format)
+
+		c, err := file.Config()
+		assert.NoError(t, err, test.format)
+		assert.Equal(t, os.Getenv("GEOFENCE_TOKEN"), c.Token, test.format)
+		assert.False(t, c.AllowPrivateIPAddresses, test.format)
+	}
+
+	// References are only replaced in string values
+	_, err := Parse([]byte(`${GEOFENCE_UNSET_KEY}: 1`), FormatYAML)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	// Referenced values are converted to the type of the setting
+	t.Setenv("GEOFENCE_RADIUS", "12.5")
+	t.Setenv("GEOFENCE_ATTEMPTS", "4")
+	t.Setenv("GEOFENCE_PRIVATE", "true")
+	t.Setenv("GEOFENCE_TTL", "90s")
+	t.Setenv("GEOFENCE_NUMERIC_TOKEN", "12345")
+	file, err := Parse([]byte(`
+token: ${GEOFENCE_NUMERIC_TOKEN}
+radius: ${GEOFENCE_RADIUS}
+allow_private_ip_addresses: ${GEOFENCE_PRIVATE}
+retry: {max_attempts: "${GEOFENCE_ATTEMPTS}"}
+cache: {ttl: "${GEOFENCE_TTL}"}
+overrides: [{cidr: 203.0.113.0/24, verdict: location, latitude: "${GEOFENCE_RADIUS}", longitude: 0}]
+`), FormatYAML)
+	assert.NoError(t, err)
+	c, err := file.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", c.Token)
+	assert.Equal(t, 12.5, c.Radius)
+	assert.True(t, c.AllowPrivateIPAddresses)
+	assert.Equal(t, 4, c.RetryPolicy.MaxAttempts)
+	assert.Equal(t, 90*time.Second, c.CacheTTL)
+	assert.Equal(t, 12.5, c.Overrides.List()[0].Latitude)
+
+	// Values that aren't of the type of the setting are still rejected
+	t.Setenv("GEOFENCE_RADIUS", "far")
+	_, err = Parse([]byte(`radius: ${GEOFENCE_RADIUS}`), FormatYAML)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		err  error
+		data string
+	}{
+		{data: `token: ${GEOFENCE_UNSET_TOKEN}`, err: ErrMissingEnv},
+		{data: `tokn: fakeApiToken`, err: ErrInvalidConfig},
+		{data: `radius: -1`, err: ErrInvalidConfig},
+		{data: `failure_policy: sometimes`, err: ErrInvalidConfig},
+		{data: `unit: furlong`, err: ErrInvalidConfig},
+		{data: `uncertain_policy: maybe`, err: ErrInvalidConfig},
+		{data: `default_accuracy_radius: -1`, err: ErrInvalidConfig},
+		{data: `{token: a, token_file: b}`, err: ErrInvalidConfig},
+		{data: `rate_limit: {exhausted: fallback}`, err: ErrInvalidConfig},
+		{data: `rate_limit: {exhausted: sometimes}`, err: ErrInvalidConfig},
+		{data: `cache: {ttl: 1 day}`, err: ErrInvalidConfig},
+		{data: `center: {latitude: 91, longitude: 0}`, err: ErrInvalidConfig},
+		{data: `providers: [{type: dbip}]`, err: ErrInvalidConfig},
+		{data: `providers: [{type: dbip, path: dbip.csv, base_url: https://dbip.example.com}]`, err: ErrInvalidConfig},
+		{data: `overrides: [{cidr: 203.0.113.0/24}]`, err: ErrInvalidConfig},
+		{data: `overrides: [{cidr: 203.0.113.0/24, verdict: location, latitude: 48.8566}]`, err: ErrInvalidConfig},
+		{data: `special_addresses: {private: {verdict: maybe}}`, err: ErrInvalidConfig},
+		{data: `special_addresses: {intranet: {verdict: allow}}`, err: ErrInvalidConfig},
+		{data: ``, err: ErrInvalidConfig},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.data), FormatYAML)
+		assert.ErrorIs(t, err, test.err, test.data)
+	}
+
+	_, err := Parse([]byte(`{}`), Format("ini"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("fakeApiToken\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "overrides.csv"), []byte("198.51.100.0/24,deny\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "dbip.csv"),
+		[]byte("8.8.8.0,8.8.8.255,NA,US,California,Mountain View,37.4056,-122.0775\n"), 0o600))
+	configFile := filepath.Join(dir, "geofence.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte(`
+token_file: token
+center:
+  latitude: 37.4056
+  longitude: -122.0775
+radius: 1
+providers:
+  - type: dbip
+    path: dbip.csv
+  - type: ipbase
+    timeout: 2s
+http:
+  base_url: https://ipbase.example.com/v2
+  proxy: http://proxy.example.com:3128
+  timeout: 5s
+overrides:
+  - cidr: 203.0.113.0/24
+    verdict: allow
+overrides_file: overrides.csv
+special_addresses:
+  link_local:
+    verdict: deny
+rate_limit:
+  monthly_quota: 1000
+  exhausted: fallback
+  fallback:
+    type: dbip
+    path: dbip.csv
+`), 0o600))
+
+	file, err := LoadFile(configFile)
+	assert.NoError(t, err)
+	c, err := file.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, "fakeApiToken", c.Token)
+	assert.IsType(t, &provider.Chain{}, c.Provider)
+	assert.Equal(t, "https://ipbase.example.com/v2", c.BaseURL)
+	assert.Equal(t, 5*time.Second, c.Timeout)
+	proxy, err := c.Transport.(*http.Transport).Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "ipbase.example.com"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.example.com:3128", proxy.Host)
+	assert.Equal(t, 2, c.Overrides.Len())
+	assert.Equal(t, geofence.VerdictDeny, c.SpecialAddresses[geofence.CategoryLinkLocal].Verdict)
+	assert.Equal(t, provider.ExhaustedFallback, c.RateLimit.Exhausted)
+	assert.IsType(t, &provider.RangeDatabase{}, c.RateLimit.Fallback)
+
+	g, err := Load(configFile)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		ipAddress      string
+		expectedSource geofence.Source
+		expectedNear   bool
+	}{
+		{ipAddress: "8.8.8.8", expectedSource: geofence.SourceLookup, expectedNear: true},
+		{ipAddress: "203.0.113.1", expectedSource: geofence.SourceOverride, expectedNear: true},
+		{ipAddress: "198.51.100.1", expectedSource: geofence.SourceOverride},
+		{ipAddress: "169.254.1.1", expectedSource: geofence.SourceSpecialAddress},
+	}
+	for _, test := range tests {
+		decision, err := g.Check(test.ipAddress)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedSource, decision.Source, test.ipAddress)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear, test.ipAddress)
+	}
+
+	_, err = LoadFile(filepath.Join(dir, "geofence.ini"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestLoadHTTPForEveryProvider(t *testing.T) {
+	// Every api provider goes through the proxy of the http section
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.Host)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status": "success", "countryCode": "US", "lat": 37.4056, "lon": -122.0775}`))
+	}))
+	defer proxy.Close()
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "geofence.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte(`
+center:
+  latitude: 37.4056
+  longitude: -122.0775
+radius: 1
+providers:
+  - type: ip-api
+  - type: ip-api
+    base_url: http://ip-api.example.com
+consensus:
+  min_answers: 2
+http:
+  proxy: `+proxy.URL+`
+  timeout: 5s
+`), 0o600))
+
+	g, err := Load(configFile)
+	assert.NoError(t, err)
+
+	decision, err := g.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+	assert.ElementsMatch(t, []string{"ip-api.com", "ip-api.example.com"}, proxied)
+}
+
+func TestParseQuotaRedis(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "redis-password"), []byte("fakePassword\n"), 0o600))
+	configFile := filepath.Join(dir, "geofence.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte(`
+rate_limit:
+  monthly_quota: 1000
+  quota_redis:
+    addr: localhost:6379
+    password_file: redis-password
+    db: 2
+`), 0o600))
+
+	file, err := LoadFile(configFile)
+	assert.NoError(t, err)
+	c, err := file.Config()
+	assert.NoError(t, err)
+
+	counter, ok := c.RateLimit.QuotaCounter.(*redisQuotaCounter)
+	assert.True(t, ok)
+	opts := counter.client.(*redis.Client).Options()
+	assert.Equal(t, "localhost:6379", opts.Addr)
+	assert.Equal(t, "fakePassword", opts.Password)
+	assert.Equal(t, 2, opts.DB)
+	// Closed by the geofence
+	assert.Implements(t, (*io.Closer)(nil), c.RateLimit.QuotaCounter)
+	assert.NoError(t, counter.Close())
+}
+
+func TestSchema(t *testing.T) {
+	var schema map[string]interface{}
+	assert.NoError(t, json.Unmarshal(Schema(), &schema))
+	assert.Equal(t, schemaURL, schema["$id"])
+}
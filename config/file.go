@@ -0,0 +1,531 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/circa10a/go-geofence"
+	"github.com/circa10a/go-geofence/cache"
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultQuotaKeyPrefix = "geofence:quota:"
+)
+
+// File is a configuration file, see schema.json for the meaning of each field
+type File struct {
+	Center           *geofence.Coordinates           `json:"center"`
+	CenterRefresh    *CenterRefresh                  `json:"center_refresh"`
+	HTTP             *HTTP                           `json:"http"`
+	Consensus        *Consensus                      `json:"consensus"`
+	Retry            *Retry                          `json:"retry"`
+	CircuitBreaker   *CircuitBreaker                 `json:"circuit_breaker"`
+	RateLimit        *RateLimit                      `json:"rate_limit"`
+	Cache            *Cache                          `json:"cache"`
+	SpecialAddresses map[string]SpecialAddressPolicy `json:"special_addresses"`
+	Token            string                          `json:"token"`
+	TokenFile        string                          `json:"token_file"`
+	IPAddress        string                          `json:"ip_address"`
+	Place            string                          `json:"place"`
+	Unit             string                          `json:"unit"`
+	DistanceFormula  string                          `json:"distance_formula"`
+	FailurePolicy    string                          `json:"failure_policy"`
+	UncertainPolicy  string                          `json:"uncertain_policy"`
+	OverridesFile    string                          `json:"overrides_file"`
+	// dir is the directory relative paths are resolved against
+	dir                     string
+	Providers               []Provider          `json:"providers"`
+	Overrides               []geofence.Override `json:"overrides"`
+	Radius                  float64             `json:"radius"`
+	DefaultAccuracyRadius   float64             `json:"default_accuracy_radius"`
+	LazyCenter              bool                `json:"lazy_center"`
+	AllowPrivateIPAddresses bool                `json:"allow_private_ip_addresses"`
+}
+
+// CenterRefresh configures geofence.CenterRefreshOptions
+type CenterRefresh struct {
+	Interval             Duration `json:"interval"`
+	NetworkCheckInterval Duration `json:"network_check_interval"`
+	MinDistance          float64  `json:"min_distance"`
+}
+
+// HTTP configures the requests of every api provider. BaseURL only applies to ipbase.com.
+type HTTP struct {
+	BaseURL string   `json:"base_url"`
+	Proxy   string   `json:"proxy"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Provider is a geolocation provider
+type Provider struct {
+	Type              string   `json:"type"`
+	Name              string   `json:"name"`
+	Token             string   `json:"token"`
+	TokenFile         string   `json:"token_file"`
+	Path              string   `json:"path"`
+	BaseURL           string   `json:"base_url"`
+	Timeout           Duration `json:"timeout"`
+	MaxAccuracyRadius float64  `json:"max_accuracy_radius"`
+}
+
+// Consensus configures provider.ConsensusOptions
+type Consensus struct {
+	Timeout                Duration `json:"timeout"`
+	MinAnswers             int      `json:"min_answers"`
+	MaxSpread              float64  `json:"max_spread"`
+	RequireCountryMajority bool     `json:"require_country_majority"`
+}
+
+// Retry configures provider.RetryPolicy
+type Retry struct {
+	RetryableStatusCodes []int    `json:"retryable_status_codes"`
+	MaxAttempts          int      `json:"max_attempts"`
+	InitialBackoff       Duration `json:"initial_backoff"`
+	MaxBackoff           Duration `json:"max_backoff"`
+	Multiplier           float64  `json:"multiplier"`
+	Jitter               float64  `json:"jitter"`
+}
+
+// CircuitBreaker configures provider.BreakerOptions
+type CircuitBreaker struct {
+	FailureThreshold int      `json:"failure_threshold"`
+	OpenTimeout      Duration `json:"open_timeout"`
+	HalfOpenProbes   int      `json:"half_open_probes"`
+}
+
+// RateLimit configures provider.RateLimitOptions
+type RateLimit struct {
+	// Fallback looks up ip addresses once the budget is used up with exhausted: fallback
+	Fallback *Provider `json:"fallback"`
+	// QuotaRedis counts monthly_quota in redis, shared by every instance. Its key_prefix defaults to geofence:quota:.
+	QuotaRedis   *Redis   `json:"quota_redis"`
+	Exhausted    string   `json:"exhausted"`
+	Limit        int      `json:"limit"`
+	Interval     Duration `json:"interval"`
+	Burst        int      `json:"burst"`
+	MonthlyQuota int64    `json:"monthly_quota"`
+}
+
+// Cache configures the cache
+type Cache struct {
+	Redis        *Redis     `json:"redis"`
+	Memcached    *Memcached `json:"memcached"`
+	Disk         *Disk      `json:"disk"`
+	SnapshotFile string     `json:"snapshot_file"`
+	TTL          Duration   `json:"ttl"`
+	SoftTTL      Duration   `json:"soft_ttl"`
+	StaleTTL     Duration   `json:"stale_ttl"`
+	NegativeTTL  Duration   `json:"negative_ttl"`
+}
+
+// Redis configures cache.RedisOptions
+type Redis struct {
+	Addr         string   `json:"addr"`
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	PasswordFile string   `json:"password_file"`
+	MasterName   string   `json:"master_name"`
+	KeyPrefix    string   `json:"key_prefix"`
+	Addrs        []string `json:"addrs"`
+	DB           int      `json:"db"`
+}
+
+// Memcached configures cache.MemcachedOptions
+type Memcached struct {
+	KeyPrefix string   `json:"key_prefix"`
+	Servers   []string `json:"servers"`
+	Timeout   Duration `json:"timeout"`
+}
+
+// Disk configures cache.DiskOptions
+type Disk struct {
+	Path               string   `json:"path"`
+	CompactionInterval Duration `json:"compaction_interval"`
+}
+
+// SpecialAddressPolicy configures geofence.SpecialAddressPolicy
+type SpecialAddressPolicy struct {
+	Verdict   string  `json:"verdict"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+var failurePolicies = map[string]geofence.FailurePolicy{
+	"":            geofence.FailurePolicyError,
+	"error":       geofence.FailurePolicyError,
+	"open":        geofence.FailurePolicyOpen,
+	"closed":      geofence.FailurePolicyClosed,
+	"serve_stale": geofence.FailurePolicyServeStale,
+}
+
+var exhaustedBehaviors = map[string]provider.ExhaustedBehavior{
+	"":         provider.ExhaustedWait,
+	"wait":     provider.ExhaustedWait,
+	"fail":     provider.ExhaustedFail,
+	"fallback": provider.ExhaustedFallback,
+}
+
+var uncertainPolicies = map[string]geofence.UncertainPolicy{
+	"":       geofence.UncertainPolicyPoint,
+	"point":  geofence.UncertainPolicyPoint,
+	"open":   geofence.UncertainPolicyOpen,
+	"closed": geofence.UncertainPolicyClosed,
+}
+
+// Config builds the configuration of a geofence, reading secret and database files
+func (f *File) Config() (*geofence.Config, error) {
+	rules, err := f.Rules()
+	if err != nil {
+		return nil, err
+	}
+	c := &geofence.Config{
+		IPAddress:               f.IPAddress,
+		Place:                   rules.Place,
+		Center:                  rules.Center,
+		Radius:                  rules.Radius,
+		DefaultAccuracyRadius:   rules.DefaultAccuracyRadius,
+		Unit:                    rules.Unit,
+		DistanceFormula:         rules.DistanceFormula,
+		LazyCenter:              f.LazyCenter,
+		AllowPrivateIPAddresses: rules.AllowPrivateIPAddresses,
+		FailurePolicy:           rules.FailurePolicy,
+		UncertainPolicy:         rules.UncertainPolicy,
+		Overrides:               rules.Overrides,
+		SpecialAddresses:        rules.SpecialAddresses,
+	}
+
+	c.Token, err = f.secret(f.Token, f.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Provider, err = f.provider(c.Token)
+	if err != nil {
+		return nil, err
+	}
+	// Used by the default ipbase.com provider when providers isn't set
+	ipbase, err := f.httpOptions(Provider{Type: "ipbase"})
+	if err != nil {
+		return nil, err
+	}
+	c.Transport, c.BaseURL, c.Timeout = ipbase.Transport, ipbase.BaseURL, ipbase.Timeout
+
+	if f.CenterRefresh != nil {
+		c.CenterRefresh = &geofence.CenterRefreshOptions{
+			Interval:             time.Duration(f.CenterRefresh.Interval),
+			NetworkCheckInterval: time.Duration(f.CenterRefresh.NetworkCheckInterval),
+			MinDistance:          f.CenterRefresh.MinDistance,
+		}
+	}
+	if f.Retry != nil {
+		c.RetryPolicy = &provider.RetryPolicy{
+			RetryableStatusCodes: f.Retry.RetryableStatusCodes,
+			MaxAttempts:          f.Retry.MaxAttempts,
+			InitialBackoff:       time.Duration(f.Retry.InitialBackoff),
+			MaxBackoff:           time.Duration(f.Retry.MaxBackoff),
+			Multiplier:           f.Retry.Multiplier,
+			Jitter:               f.Retry.Jitter,
+		}
+	}
+	if f.CircuitBreaker != nil {
+		c.CircuitBreaker = &provider.BreakerOptions{
+			FailureThreshold: f.CircuitBreaker.FailureThreshold,
+			OpenTimeout:      time.Duration(f.CircuitBreaker.OpenTimeout),
+			HalfOpenProbes:   f.CircuitBreaker.HalfOpenProbes,
+		}
+	}
+	if f.RateLimit != nil {
+		c.RateLimit = &provider.RateLimitOptions{
+			Limit:        f.RateLimit.Limit,
+			Interval:     time.Duration(f.RateLimit.Interval),
+			Burst:        f.RateLimit.Burst,
+			MonthlyQuota: f.RateLimit.MonthlyQuota,
+		}
+		c.RateLimit.Exhausted = exhaustedBehaviors[f.RateLimit.Exhausted]
+		if f.RateLimit.Fallback != nil {
+			c.RateLimit.Fallback, err = f.buildProvider(*f.RateLimit.Fallback, c.Token)
+			if err != nil {
+				return nil, fmt.Errorf("rate_limit fallback (%s): %w", f.RateLimit.Fallback.Type, err)
+			}
+		}
+	}
+
+	err = f.cache(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connects to redis, so it's set up last
+	if f.RateLimit != nil && f.RateLimit.QuotaRedis != nil {
+		c.RateLimit.QuotaCounter, err = f.redisQuotaCounter(f.RateLimit.QuotaRedis)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Rules builds the rules of a geofence, which can be applied to a running geofence with Geofence.Reload
+func (f *File) Rules() (*geofence.Rules, error) {
+	overrides, err := f.overrides()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &geofence.Rules{
+		Center:                  f.Center,
+		Overrides:               overrides,
+		Place:                   f.Place,
+		Unit:                    geofence.Unit(f.Unit),
+		DistanceFormula:         geofence.DistanceFormula(f.DistanceFormula),
+		Radius:                  f.Radius,
+		DefaultAccuracyRadius:   f.DefaultAccuracyRadius,
+		FailurePolicy:           failurePolicies[f.FailurePolicy],
+		UncertainPolicy:         uncertainPolicies[f.UncertainPolicy],
+		AllowPrivateIPAddresses: f.AllowPrivateIPAddresses,
+	}
+	if f.SpecialAddresses != nil {
+		rules.SpecialAddresses = map[geofence.AddressCategory]geofence.SpecialAddressPolicy{}
+		for category, policy := range f.SpecialAddresses {
+			rules.SpecialAddresses[geofence.AddressCategory(category)] = geofence.SpecialAddressPolicy{
+				Verdict:   geofence.Verdict(policy.Verdict),
+				Country:   policy.Country,
+				Latitude:  policy.Latitude,
+				Longitude: policy.Longitude,
+			}
+		}
+	}
+	return rules, nil
+}
+
+// ruleFiles returns the files Rules reads besides the configuration file
+func (f *File) ruleFiles() []string {
+	if f.OverridesFile == "" {
+		return nil
+	}
+	return []string{f.path(f.OverridesFile)}
+}
+
+// path resolves a path relative to the directory of the configuration file
+func (f *File) path(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(f.dir, path)
+}
+
+// secret returns value, or the trimmed contents of file if it's set
+func (f *File) secret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(f.path(file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// provider builds the providers, nil for the default ipbase.com provider.
+// Several providers are chained in order, or combined if consensus is set.
+func (f *File) provider(token string) (provider.Provider, error) {
+	if len(f.Providers) == 0 {
+		return nil, nil
+	}
+
+	providers := make([]provider.Provider, len(f.Providers))
+	links := make([]provider.ChainLink, len(f.Providers))
+	for i, p := range f.Providers {
+		built, err := f.buildProvider(p, token)
+		if err != nil {
+			return nil, fmt.Errorf("providers %d (%s): %w", i+1, p.Type, err)
+		}
+		providers[i] = built
+		links[i] = provider.ChainLink{
+			Provider:          built,
+			Name:              p.Name,
+			Timeout:           time.Duration(p.Timeout),
+			MaxAccuracyRadius: p.MaxAccuracyRadius,
+		}
+	}
+
+	if f.Consensus != nil {
+		return provider.NewConsensus(&provider.ConsensusOptions{
+			Timeout:                time.Duration(f.Consensus.Timeout),
+			MinAnswers:             f.Consensus.MinAnswers,
+			MaxSpread:              f.Consensus.MaxSpread,
+			RequireCountryMajority: f.Consensus.RequireCountryMajority,
+		}, providers...), nil
+	}
+	return provider.NewChain(links...), nil
+}
+
+// buildProvider builds a single provider. Ipbase uses the top level token unless it has its own.
+func (f *File) buildProvider(p Provider, token string) (provider.Provider, error) {
+	providerToken, err := f.secret(p.Token, p.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.Type {
+	case "ipbase", "ip-api", "ipinfo", "ipgeolocation":
+		opts, err := f.httpOptions(p)
+		if err != nil {
+			return nil, err
+		}
+		switch p.Type {
+		case "ip-api":
+			return provider.NewIPAPIProviderWithOptions(providerToken, opts), nil
+		case "ipinfo":
+			return provider.NewIPInfoProviderWithOptions(providerToken, opts), nil
+		case "ipgeolocation":
+			return provider.NewIPGeolocationProviderWithOptions(providerToken, opts), nil
+		}
+		if providerToken == "" {
+			providerToken = token
+		}
+		return geofence.NewIPBaseProviderWithOptions(providerToken, opts), nil
+	}
+
+	database, err := os.Open(f.path(p.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	switch {
+	case p.Type == "dbip":
+		return provider.LoadDBIPCSV(database)
+	case strings.EqualFold(filepath.Ext(p.Path), ".bin"):
+		return provider.LoadIP2LocationBIN(database)
+	default:
+		return provider.LoadIP2LocationCSV(database)
+	}
+}
+
+// httpOptions builds the options of an api provider from the http section and its own base_url
+func (f *File) httpOptions(p Provider) (*provider.HTTPOptions, error) {
+	opts := &provider.HTTPOptions{BaseURL: p.BaseURL}
+	if f.HTTP == nil {
+		return opts, nil
+	}
+	if opts.BaseURL == "" && p.Type == "ipbase" {
+		opts.BaseURL = f.HTTP.BaseURL
+	}
+	opts.Timeout = time.Duration(f.HTTP.Timeout)
+	if f.HTTP.Proxy != "" {
+		proxy, err := url.Parse(f.HTTP.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: proxy: %s", ErrInvalidConfig, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		opts.Transport = transport
+	}
+	return opts, nil
+}
+
+// cache sets the cache options of c
+func (f *File) cache(c *geofence.Config) error {
+	if f.Cache == nil {
+		return nil
+	}
+
+	c.CacheTTL = time.Duration(f.Cache.TTL)
+	c.CacheSoftTTL = time.Duration(f.Cache.SoftTTL)
+	c.StaleCacheTTL = time.Duration(f.Cache.StaleTTL)
+	c.NegativeCacheTTL = time.Duration(f.Cache.NegativeTTL)
+	c.CacheSnapshotFile = f.path(f.Cache.SnapshotFile)
+
+	if f.Cache.Redis != nil {
+		redisOptions, err := f.redisOptions(f.Cache.Redis)
+		if err != nil {
+			return err
+		}
+		c.RedisOptions = redisOptions
+	}
+	if memcached := f.Cache.Memcached; memcached != nil {
+		c.MemcachedOptions = &cache.MemcachedOptions{
+			Servers:   memcached.Servers,
+			KeyPrefix: memcached.KeyPrefix,
+			Timeout:   time.Duration(memcached.Timeout),
+		}
+	}
+	if disk := f.Cache.Disk; disk != nil {
+		c.DiskOptions = &cache.DiskOptions{
+			Path:               f.path(disk.Path),
+			CompactionInterval: time.Duration(disk.CompactionInterval),
+		}
+	}
+	return nil
+}
+
+// redisOptions builds the options of a redis connection
+func (f *File) redisOptions(r *Redis) (*cache.RedisOptions, error) {
+	password, err := f.secret(r.Password, r.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cache.RedisOptions{
+		Addr:       r.Addr,
+		Addrs:      r.Addrs,
+		Username:   r.Username,
+		Password:   password,
+		MasterName: r.MasterName,
+		DB:         r.DB,
+		KeyPrefix:  r.KeyPrefix,
+	}, nil
+}
+
+// redisQuotaCounter connects to redis to count the monthly quota
+func (f *File) redisQuotaCounter(r *Redis) (provider.QuotaCounter, error) {
+	redisOptions, err := f.redisOptions(r)
+	if err != nil {
+		return nil, err
+	}
+	keyPrefix := redisOptions.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = defaultQuotaKeyPrefix
+	}
+	client := cache.NewRedisClient(redisOptions)
+	return &redisQuotaCounter{
+		RedisQuotaCounter: provider.NewRedisQuotaCounter(client, keyPrefix),
+		client:            client,
+	}, nil
+}
+
+// redisQuotaCounter is a provider.RedisQuotaCounter with its own connection, closed by Geofence.Close
+type redisQuotaCounter struct {
+	*provider.RedisQuotaCounter
+	client redis.UniversalClient
+}
+
+// Close closes the connection to redis
+func (r *redisQuotaCounter) Close() error {
+	return r.client.Close()
+}
+
+// overrides builds the overrides listed inline and in overrides_file
+func (f *File) overrides() (*geofence.Overrides, error) {
+	overrides := append([]geofence.Override(nil), f.Overrides...)
+	if f.OverridesFile != "" {
+		fromFile, err := geofence.LoadOverridesFile(f.path(f.OverridesFile))
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, fromFile.List()...)
+	}
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	return geofence.NewOverrides(overrides)
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/circa10a/go-geofence"
+)
+
+const (
+	defaultWatchInterval = time.Second
+)
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	// OnReload is called after every reload of a changed file, with nil on success
+	// or the error that left the previous rules in effect
+	OnReload func(err error)
+	// Interval between checks of the file for changes. Defaults to 1s.
+	Interval time.Duration
+}
+
+// Watcher reloads the rules of a geofence when its configuration file changes
+type Watcher struct {
+	geofence *geofence.Geofence
+	opts     WatchOptions
+	stop     chan struct{}
+	path     string
+	// files are the files the rules were read from besides path, such as overrides_file
+	files []string
+	// sum is the sha256 of the content of path and files last reloaded, successfully or not
+	sum      []byte
+	done     sync.WaitGroup
+	mu       sync.Mutex
+	stopOnce sync.Once
+}
+
+// Watch polls a configuration file and applies its rules to g with Geofence.Reload whenever its content
+// or the content of a file its rules reference, such as overrides_file, changes.
+// Only the rules are reloaded, settings such as the provider or cache require a new geofence.
+// The file is assumed to be the one g was loaded from, so its current content isn't reloaded until it changes.
+func Watch(path string, g *geofence.Geofence, opts *WatchOptions) *Watcher {
+	w := newWatcher(path, g, opts)
+	ticker := time.NewTicker(w.opts.Interval)
+	w.start(ticker.C, ticker.Stop)
+	return w
+}
+
+// newWatcher sets up a watcher of path that checks the file once started
+func newWatcher(path string, g *geofence.Geofence, opts *WatchOptions) *Watcher {
+	w := &Watcher{
+		geofence: g,
+		path:     path,
+		stop:     make(chan struct{}),
+	}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.Interval <= 0 {
+		w.opts.Interval = defaultWatchInterval
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if file, err := w.parse(data); err == nil {
+			w.files = file.ruleFiles()
+		}
+		w.sum = w.checksum(data)
+	}
+	return w
+}
+
+// start checks the file on every tick in the background until Close is called, then calls stopTicks
+func (w *Watcher) start(ticks <-chan time.Time, stopTicks func()) {
+	w.done.Add(1)
+	go w.watch(ticks, stopTicks)
+}
+
+// watch checks the file on every tick until Close is called
+func (w *Watcher) watch(ticks <-chan time.Time, stopTicks func()) {
+	defer w.done.Done()
+	defer stopTicks()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticks:
+			w.reload(false)
+		}
+	}
+}
+
+// Reload reloads the file now, even if it didn't change
+func (w *Watcher) Reload() error {
+	return w.reload(true)
+}
+
+// reload applies the rules of the file if it changed or force is set and reports the result to OnReload
+func (w *Watcher) reload(force bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		// Editors may briefly remove a file while saving it, so only forced reloads report a missing file
+		if force {
+			w.notify(err)
+		}
+		return err
+	}
+	sum := w.checksum(data)
+	if !force && bytes.Equal(sum, w.sum) {
+		return nil
+	}
+	// A file that failed to reload isn't retried until it changes again
+	w.sum = sum
+
+	err = w.apply(data)
+	w.notify(err)
+	return err
+}
+
+// apply parses data and reloads the rules of the geofence
+func (w *Watcher) apply(data []byte) error {
+	file, err := w.parse(data)
+	if err != nil {
+		return err
+	}
+	// The file may reference other files now
+	w.files = file.ruleFiles()
+	w.sum = w.checksum(data)
+
+	rules, err := file.Rules()
+	if err != nil {
+		return err
+	}
+	return w.geofence.Reload(*rules)
+}
+
+// parse parses data as the content of the watched file
+func (w *Watcher) parse(data []byte) (*File, error) {
+	format, err := FormatFromPath(w.path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", w.path, err)
+	}
+	file.dir = filepath.Dir(w.path)
+	return file, nil
+}
+
+// checksum returns the sha256 of data, the content of the watched file, and of the files its rules reference.
+// Referenced files that can't be read count as empty.
+func (w *Watcher) checksum(data []byte) []byte {
+	hash := sha256.New()
+	hash.Write(data)
+	for _, path := range w.files {
+		hash.Write([]byte(path))
+		if content, err := os.ReadFile(path); err == nil {
+			hash.Write(content)
+		}
+	}
+	return hash.Sum(nil)
+}
+
+// notify calls OnReload if set
+func (w *Watcher) notify(err error) {
+	if w.opts.OnReload != nil {
+		w.opts.OnReload(err)
+	}
+}
+
+// Close stops watching the file and waits for a reload in progress to finish
+func (w *Watcher) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	w.done.Wait()
+}
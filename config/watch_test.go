@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence"
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "geofence.yaml")
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(configFile, []byte(content), 0o600))
+	}
+	write("place: Paris, FR\nradius: 100\n")
+
+	file, err := LoadFile(configFile)
+	assert.NoError(t, err)
+	c, err := file.Config()
+	assert.NoError(t, err)
+	c.Provider = provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 51.5072, Longitude: -0.1276}, nil
+	})
+	g, err := geofence.New(c)
+	assert.NoError(t, err)
+
+	reloads := make(chan error, 10)
+	watcher, ticks := startManualWatch(configFile, g, &WatchOptions{
+		OnReload: func(err error) {
+			reloads <- err
+		},
+	})
+	defer watcher.Close()
+
+	decision, err := g.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.False(t, decision.IsIPAddressNear)
+
+	// Unchanged files aren't reloaded
+	ticks <- time.Now()
+	write("place: Paris, FR\nradius: 500\n")
+	ticks <- time.Now()
+	assert.NoError(t, <-reloads)
+	decision, err = g.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+
+	// Invalid files keep the current rules
+	write("place: Paris, FR\nradius: -1\n")
+	ticks <- time.Now()
+	assert.ErrorIs(t, <-reloads, ErrInvalidConfig)
+	write("place: Atlantis\nradius: 1\n")
+	ticks <- time.Now()
+	assert.ErrorIs(t, <-reloads, geofence.ErrUnknownPlace)
+	assert.Equal(t, 500.0, g.Rules().Radius)
+
+	assert.ErrorIs(t, watcher.Reload(), geofence.ErrUnknownPlace)
+	assert.ErrorIs(t, <-reloads, geofence.ErrUnknownPlace)
+
+	// Nothing receives ticks once closed
+	watcher.Close()
+	select {
+	case ticks <- time.Now():
+		t.Fatal("watcher still running after Close")
+	default:
+	}
+	assert.Empty(t, reloads)
+}
+
+// startManualWatch watches path on ticks sent by the test
+func startManualWatch(path string, g *geofence.Geofence, opts *WatchOptions) (*Watcher, chan<- time.Time) {
+	ticks := make(chan time.Time)
+	w := newWatcher(path, g, opts)
+	w.start(ticks, func() {})
+	return w, ticks
+}
+
+func TestWatchOverridesFile(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "geofence.yaml")
+	overridesFile := filepath.Join(dir, "overrides.csv")
+	assert.NoError(t, os.WriteFile(configFile, []byte("place: Paris, FR\nradius: 100\noverrides_file: overrides.csv\n"), 0o600))
+	assert.NoError(t, os.WriteFile(overridesFile, []byte("203.0.113.0/24,deny\n"), 0o600))
+
+	file, err := LoadFile(configFile)
+	assert.NoError(t, err)
+	c, err := file.Config()
+	assert.NoError(t, err)
+	c.Provider = provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 51.5072, Longitude: -0.1276}, nil
+	})
+	g, err := geofence.New(c)
+	assert.NoError(t, err)
+
+	reloads := make(chan error, 10)
+	watcher, ticks := startManualWatch(configFile, g, &WatchOptions{
+		OnReload: func(err error) {
+			reloads <- err
+		},
+	})
+	defer watcher.Close()
+
+	decision, err := g.Check("203.0.113.1")
+	assert.NoError(t, err)
+	assert.False(t, decision.IsIPAddressNear)
+
+	// Changing only the overrides file reloads the rules
+	assert.NoError(t, os.WriteFile(overridesFile, []byte("203.0.113.0/24,allow\n"), 0o600))
+	ticks <- time.Now()
+	assert.NoError(t, <-reloads)
+	decision, err = g.Check("203.0.113.1")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+}
@@ -0,0 +1,52 @@
+package geofence
+
+import (
+	"github.com/circa10a/go-geofence/provider"
+)
+
+// Source is where the answer of a Decision came from
+type Source string
+
+const (
+	// SourceLookup means the ip address was looked up with the provider
+	SourceLookup Source = "lookup"
+	// SourceCache means the result was served from the cache
+	SourceCache Source = "cache"
+	// SourceNegativeCache means the ip address recently failed to be looked up and the remembered failure was returned,
+	// see Config.NegativeCacheTTL
+	SourceNegativeCache Source = "negative_cache"
+	// SourcePrivate means the ip address is private or loopback and AllowPrivateIPAddresses is set
+	SourcePrivate Source = "private"
+	// SourceSpecialAddress means the ip address is a special-purpose address decided by Config.SpecialAddresses
+	SourceSpecialAddress Source = "special_address"
+	// SourceOverride means the ip address is covered by one of Config.Overrides
+	SourceOverride Source = "override"
+	// SourceFailurePolicy means the lookup failed and the answer comes from Config.FailurePolicy
+	SourceFailurePolicy Source = "failure_policy"
+)
+
+// Decision describes how Check reached its answer for an ip address
+type Decision struct {
+	// Location is the looked up location, set for SourceLookup and location overrides
+	Location *provider.Location
+	// Override is the override covering the ip address, only set for SourceOverride
+	Override *Override
+	// Agreement describes how the sources of a provider.Consensus agreed, also set when they disagreed too much
+	Agreement *provider.Agreement
+	// Err is the lookup error that the failure policy answered for
+	Err error
+	// IPAddress is the canonical form of the ip address, ipv4-mapped addresses are unmapped and zones stripped
+	IPAddress string
+	Source    Source
+	// Category of a special-purpose address, only set for SourcePrivate and SourceSpecialAddress
+	Category AddressCategory
+	// Proximity takes the accuracy radius of the location into account, set along with Location and for cached results
+	Proximity Proximity
+	// fingerprint identifies the geofence the location was compared to
+	fingerprint string
+	// Distance from the center of the geofence in the unit of the rules, kilometers by default. Set along with Location.
+	Distance float64
+	// Attempts is how many provider requests the lookup took, including retries
+	Attempts        int
+	IsIPAddressNear bool
+}
@@ -0,0 +1,151 @@
+package geofence
+
+import (
+	"math"
+
+	"github.com/EpicStep/go-simple-geo/v2/geo"
+)
+
+const (
+	// wgs84SemiMajorAxis is the equatorial radius of the WGS-84 ellipsoid in meters
+	wgs84SemiMajorAxis = 6378137.0
+	// wgs84Flattening is the flattening of the WGS-84 ellipsoid
+	wgs84Flattening = 1 / 298.257223563
+	// vincentyMaxIterations bounds the iterations of Vincenty's formula, which may not converge for nearly antipodal points
+	vincentyMaxIterations = 200
+)
+
+// Unit is a unit of distance
+type Unit string
+
+const (
+	// UnitKilometers is the default unit
+	UnitKilometers Unit = "km"
+	// UnitMiles are statute miles of 1609.344 meters
+	UnitMiles Unit = "mi"
+	// UnitNauticalMiles are international nautical miles of 1852 meters
+	UnitNauticalMiles Unit = "nmi"
+	// UnitMeters are meters
+	UnitMeters Unit = "m"
+)
+
+// unitKilometers is how many kilometers make one of each unit
+var unitKilometers = map[Unit]float64{
+	"":                1,
+	UnitKilometers:    1,
+	UnitMiles:         1.609344,
+	UnitNauticalMiles: 1.852,
+	UnitMeters:        0.001,
+}
+
+// valid reports whether the unit is known, empty means kilometers
+func (u Unit) valid() bool {
+	_, ok := unitKilometers[u]
+	return ok
+}
+
+// FromKilometers converts a distance in kilometers to the unit
+func (u Unit) FromKilometers(kilometers float64) float64 {
+	return kilometers / unitKilometers[u]
+}
+
+// ToKilometers converts a distance in the unit to kilometers
+func (u Unit) ToKilometers(distance float64) float64 {
+	return distance * unitKilometers[u]
+}
+
+// DistanceFormula is how the distance between two coordinates is computed
+type DistanceFormula string
+
+const (
+	// FormulaHaversine is the great-circle distance on a sphere with the mean radius of the earth, 6371km.
+	// It's the default and is fast, but can be off by up to 0.5%.
+	FormulaHaversine DistanceFormula = "haversine"
+	// FormulaVincenty is the geodesic distance on the WGS-84 ellipsoid, accurate to within millimeters.
+	// It's slower and falls back to FormulaHaversine for nearly antipodal points where it doesn't converge.
+	FormulaVincenty DistanceFormula = "vincenty"
+)
+
+// valid reports whether the formula is known, empty means FormulaHaversine
+func (f DistanceFormula) valid() bool {
+	return f == "" || f == FormulaHaversine || f == FormulaVincenty
+}
+
+// Distance between two coordinates using formula, in unit
+func (c Coordinates) Distance(to Coordinates, formula DistanceFormula, unit Unit) float64 {
+	var kilometers float64
+	if formula == FormulaVincenty {
+		kilometers = vincenty(c, to)
+	} else {
+		kilometers = haversine(c, to)
+	}
+	return unit.FromKilometers(kilometers)
+}
+
+// Distance from the center of the geofence to the coordinates, using the formula and unit of the rules in effect
+func (g *Geofence) Distance(to Coordinates) float64 {
+	rules := g.currentRules()
+	return g.Center().Distance(to, rules.DistanceFormula, rules.Unit)
+}
+
+// haversine returns the great-circle distance in kilometers
+func haversine(from, to Coordinates) float64 {
+	return geo.NewCoordinatesFromDegrees(from.Latitude, from.Longitude).
+		Distance(geo.NewCoordinatesFromDegrees(to.Latitude, to.Longitude))
+}
+
+// vincenty returns the geodesic distance on the WGS-84 ellipsoid in kilometers
+// using the inverse formula of https://www.ngs.noaa.gov/PUBS_LIB/inverse.pdf
+func vincenty(from, to Coordinates) float64 {
+	const (
+		a = wgs84SemiMajorAxis
+		f = wgs84Flattening
+		b = (1 - f) * a
+	)
+
+	l := degreesToRadians(to.Longitude - from.Longitude)
+	u1 := math.Atan((1 - f) * math.Tan(degreesToRadians(from.Latitude)))
+	u2 := math.Atan((1 - f) * math.Tan(degreesToRadians(to.Latitude)))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+	for i := 0; i < vincentyMaxIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		// Coincident points
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha := 1 - sinAlpha*sinAlpha
+		// Both points on the equator
+		cos2SigmaM := 0.0
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		c := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+
+		previous := lambda
+		lambda = l + (1-c)*f*sinAlpha*(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-previous) > 1e-12 {
+			continue
+		}
+
+		uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+		bigA := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+		bigB := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+		deltaSigma := bigB * sinSigma * (cos2SigmaM + bigB/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			bigB/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		return b * bigA * (sigma - deltaSigma) / 1000
+	}
+
+	return haversine(from, to)
+}
+
+// degreesToRadians converts an angle in degrees to radians
+func degreesToRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,92 @@
+package geofence
+
+import (
+	"context"
+	"testing"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinatesDistance(t *testing.T) {
+	flindersPeak := Coordinates{Latitude: -37.95103342, Longitude: 144.42486789}
+	buninyong := Coordinates{Latitude: -37.65282114, Longitude: 143.92649554}
+	newYork := Coordinates{Latitude: 40.7143, Longitude: -74.006}
+	sanFrancisco := Coordinates{Latitude: 37.7749, Longitude: -122.419}
+
+	tests := []struct {
+		formula  DistanceFormula
+		unit     Unit
+		from     Coordinates
+		to       Coordinates
+		expected float64
+		delta    float64
+	}{
+		{from: newYork, to: sanFrancisco, expected: 4129.02, delta: 0.01},
+		{from: newYork, to: sanFrancisco, formula: FormulaHaversine, unit: UnitKilometers, expected: 4129.02, delta: 0.01},
+		{from: newYork, to: sanFrancisco, unit: UnitMiles, expected: 2565.65, delta: 0.01},
+		{from: newYork, to: sanFrancisco, unit: UnitNauticalMiles, expected: 2229.49, delta: 0.01},
+		{from: newYork, to: sanFrancisco, unit: UnitMeters, expected: 4129020, delta: 10},
+		// Geodesic of the inverse formula paper, 54972.271m
+		{from: flindersPeak, to: buninyong, formula: FormulaVincenty, unit: UnitMeters, expected: 54972.271, delta: 0.001},
+		{from: flindersPeak, to: buninyong, formula: FormulaVincenty, expected: 54.972271, delta: 0.000001},
+		{from: newYork, to: newYork, formula: FormulaVincenty, expected: 0},
+		// Equator
+		{from: Coordinates{}, to: Coordinates{Longitude: 1}, formula: FormulaVincenty, unit: UnitMeters, expected: 111319.491, delta: 0.001},
+		// Nearly antipodal points fall back to haversine
+		{from: Coordinates{}, to: Coordinates{Latitude: 0.5, Longitude: 179.7}, formula: FormulaVincenty, expected: 19950.25, delta: 0.01},
+	}
+	for _, test := range tests {
+		assert.InDelta(t, test.expected, test.from.Distance(test.to, test.formula, test.unit), test.delta)
+	}
+}
+
+func TestUnit(t *testing.T) {
+	assert.Equal(t, 1.0, UnitMiles.FromKilometers(1.609344))
+	assert.Equal(t, 1.852, UnitNauticalMiles.ToKilometers(1))
+	assert.Equal(t, 1000.0, UnitMeters.FromKilometers(1))
+	assert.Equal(t, 5.0, Unit("").ToKilometers(5))
+}
+
+func TestGeofenceUnit(t *testing.T) {
+	// Denver, about 156km or 97 miles from Cheyenne
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 39.7392, Longitude: -104.9903}, nil
+	})
+
+	tests := []struct {
+		unit         Unit
+		formula      DistanceFormula
+		radius       float64
+		expectedNear bool
+	}{
+		{radius: 160, expectedNear: true},
+		{radius: 150},
+		{unit: UnitMiles, radius: 100, expectedNear: true},
+		{unit: UnitMiles, radius: 90},
+		{unit: UnitNauticalMiles, radius: 90, expectedNear: true},
+		{unit: UnitMeters, radius: 160},
+		// The ellipsoid is slightly shorter here
+		{unit: UnitMiles, radius: 97.1},
+		{unit: UnitMiles, formula: FormulaVincenty, radius: 97.1, expectedNear: true},
+	}
+	for _, test := range tests {
+		geofence, err := New(&Config{
+			Provider:        fakeProvider,
+			Center:          &Coordinates{Latitude: 41.1400, Longitude: -104.8202},
+			Radius:          test.radius,
+			Unit:            test.unit,
+			DistanceFormula: test.formula,
+		})
+		assert.NoError(t, err)
+		decision, err := geofence.Check("8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear, test)
+		assert.Equal(t, geofence.Distance(Coordinates{Latitude: 39.7392, Longitude: -104.9903}), decision.Distance)
+	}
+
+	_, err := New(&Config{Provider: fakeProvider, Unit: "furlong"})
+	assert.ErrorIs(t, err, ErrInvalidUnit)
+	_, err = New(&Config{Provider: fakeProvider, DistanceFormula: "flat"})
+	assert.ErrorIs(t, err, ErrInvalidDistanceFormula)
+}
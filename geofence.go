@@ -2,177 +2,605 @@ package geofence
 
 import (
 	"errors"
+	"io"
 	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/EpicStep/go-simple-geo/v2/geo"
 	"github.com/circa10a/go-geofence/cache"
+	"github.com/circa10a/go-geofence/provider"
 	"github.com/go-resty/resty/v2"
+	gocache "github.com/patrickmn/go-cache"
 	"golang.org/x/net/context"
 )
 
 const (
-	ipBaseBaseURL = "https://api.ipbase.com/v2"
+	deleteExpiredFailuresInterval = time.Minute
 )
 
-// Config holds the user configuration to setup a new geofence
+// FailurePolicy controls how IsIPAddressNear answers when an ip address can't be looked up
+type FailurePolicy int
+
+const (
+	// FailurePolicyError returns false along with the lookup error. This is the default.
+	FailurePolicyError FailurePolicy = iota
+	// FailurePolicyOpen treats ip addresses that can't be looked up as near
+	FailurePolicyOpen
+	// FailurePolicyClosed treats ip addresses that can't be looked up as not near
+	FailurePolicyClosed
+	// FailurePolicyServeStale returns the last cached result for the ip address if one is still retained,
+	// otherwise it behaves like FailurePolicyError
+	FailurePolicyServeStale
+)
+
+// Config holds the user configuration to setup a new geofence.
+// Rules replaced with Reload aren't reflected here, see Geofence.Rules.
 type Config struct {
-	RedisOptions            *cache.RedisOptions
-	IPAddress               string
-	Token                   string
-	Radius                  float64
-	CacheTTL                time.Duration
+	// Provider looks up the location of ip addresses. Defaults to ipbase.com using Token.
+	Provider provider.Provider
+	// RetryPolicy retries failed lookups. Lookups are not retried if nil.
+	RetryPolicy *provider.RetryPolicy
+	// CircuitBreaker stops calling the provider after consecutive failures. Lookups are answered
+	// by FailurePolicy while the breaker is open. Disabled if nil.
+	CircuitBreaker *provider.BreakerOptions
+	// RateLimit limits the requests made to the provider and tracks its monthly quota. Unlimited if nil.
+	RateLimit *provider.RateLimitOptions
+	// HTTPClient makes the requests of the default ipbase.com provider. It's copied, so it isn't modified.
+	// Ignored if Provider is set, as are Transport, BaseURL and Timeout.
+	HTTPClient *http.Client
+	// Transport replaces the transport of HTTPClient, e.g. to route requests through a proxy or add client certificates.
+	// The default transport uses the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Transport http.RoundTripper
+	// Cache stores results, its own expiration applies. Takes precedence over RedisOptions, MemcachedOptions and DiskOptions.
+	Cache cache.Cache
+	// Center of the geofence. Takes precedence over Place and IPAddress, which then aren't looked up.
+	Center *Coordinates
+	// CenterRefresh looks up a center located from IPAddress again while the geofence runs. Disabled if nil.
+	CenterRefresh *CenterRefreshOptions
+	// Overrides pin ip ranges to a location or verdict before the cache and provider are consulted
+	Overrides *Overrides
+	// SpecialAddresses decides special-purpose addresses like link-local, multicast or documentation ranges
+	// by category instead of looking them up. Categories that aren't set are looked up.
+	SpecialAddresses map[AddressCategory]SpecialAddressPolicy
+	RedisOptions     *cache.RedisOptions
+	// MemcachedOptions caches in memcached. Ignored if RedisOptions is set.
+	MemcachedOptions *cache.MemcachedOptions
+	// DiskOptions persists the cache to a local file. Ignored if RedisOptions or MemcachedOptions is set.
+	DiskOptions *cache.DiskOptions
+	IPAddress   string
+	// Place is a city to center the geofence on, resolved offline with LookupPlace. Takes precedence over IPAddress.
+	Place string
+	Token string
+	// BaseURL of the ipbase.com api, e.g. for a mock server or a gateway. Defaults to https://api.ipbase.com/v2.
+	BaseURL string
+	// CacheSnapshotFile is loaded into the cache by New if it exists and written by Close.
+	// See ExportCache for the format.
+	CacheSnapshotFile string
+	// Unit of Radius and Decision.Distance. Defaults to UnitKilometers.
+	Unit Unit
+	// DistanceFormula computes distances from the center. Defaults to FormulaHaversine.
+	DistanceFormula DistanceFormula
+	// Radius of the geofence in Unit
+	Radius float64
+	// DefaultAccuracyRadius in Unit is assumed for locations the provider returns without an accuracy radius.
+	// None of the built-in providers report one, so their locations are exact points if 0.
+	DefaultAccuracyRadius float64
+	CacheTTL              time.Duration
+	// Timeout bounds each request of the default ipbase.com provider, including reading the response. Disabled if <= 0.
+	Timeout time.Duration
+	// CacheSoftTTL is how long results are fresh. Results older than this but younger than CacheTTL
+	// are returned immediately and refreshed in the background. Disabled if <= 0.
+	CacheSoftTTL time.Duration
+	// NegativeCacheTTL is how long a failed lookup is remembered before the ip address is looked up again.
+	// Failed lookups are not cached if <= 0.
+	NegativeCacheTTL time.Duration
+	// StaleCacheTTL is how long results are retained after CacheTTL to be served by FailurePolicyServeStale
+	StaleCacheTTL time.Duration
+	FailurePolicy FailurePolicy
+	// UncertainPolicy decides locations whose accuracy radius overlaps the edge of the geofence.
+	// Defaults to UncertainPolicyPoint.
+	UncertainPolicy UncertainPolicy
+	// LazyCenter defers looking up the center from IPAddress to the first lookup instead of New.
+	// Failed attempts are retried by later lookups with exponential backoff.
+	LazyCenter bool
+	// AllowPrivateIPAddresses treats private and loopback addresses as near unless SpecialAddresses sets their category
 	AllowPrivateIPAddresses bool
 }
 
-// Geofence holds a ipbase.com client, redis client, in-memory cache and user supplied config
+// Geofence holds a geolocation provider, redis client, in-memory cache and user supplied config
 type Geofence struct {
-	cache        cache.Cache
+	cache    cache.Cache
+	provider provider.Provider
+	// rules holds the *Rules in effect
+	rules    atomic.Value
+	retrier  *provider.Retrier
+	breaker  *provider.CircuitBreaker
+	limiter  *provider.RateLimiter
+	failures *gocache.Cache
+	// ipbaseClient is the client of the default ipbase.com provider, nil if Config.Provider is set
 	ipbaseClient *resty.Client
 	ctx          context.Context
-	Config       Config
-	Latitude     float64
-	Longitude    float64
-}
-
-// ipbaseResponse is the json response from ipbase.com
-type ipbaseResponse struct {
-	Data data `json:"data"`
-}
-
-// IPBaseError is the json response when there is an error from ipbase.com
-type IPBaseError struct {
-	Message string `json:"message"`
-}
-
-func (e *IPBaseError) Error() string {
-	return e.Message
+	// refreshing holds ip addresses with a background refresh in flight
+	refreshing sync.Map
+	center     center
+	Config     Config
+	Latitude   float64
+	Longitude  float64
+	// reloading serializes Reload
+	reloading sync.Mutex
 }
 
 // ErrInvalidIPAddress is the error raised when an invalid IP address is provided
 var ErrInvalidIPAddress = errors.New("invalid IP address provided")
 
-// validateIPAddress ensures valid ip address
-func validateIPAddress(ipAddress string) error {
-	if net.ParseIP(ipAddress) == nil {
-		return ErrInvalidIPAddress
-	}
-	return nil
-}
-
-// getIPGeoData fetches geolocation data for specified IP address from https://ipbase.com
-func (g *Geofence) getIPGeoData(ipAddress string) (*ipbaseResponse, error) {
-	response := &ipbaseResponse{}
-	ipbaseError := &IPBaseError{}
-
-	resp, err := g.ipbaseClient.R().
-		SetHeader("Accept", "application/json").
-		SetQueryParam("apikey", g.Config.Token).
-		SetQueryParam("ip", ipAddress).
-		SetResult(response).
-		SetError(ipbaseError).
-		Get("/info")
+// parseIPAddress parses an ip address and returns it in canonical form along with its cache key.
+// Ipv4-mapped ipv6 addresses are unmapped and zones are stripped so every spelling of an address shares a key.
+func parseIPAddress(ipAddress string) (netip.Addr, string, error) {
+	addr, err := netip.ParseAddr(ipAddress)
 	if err != nil {
-		return response, err
+		return netip.Addr{}, "", ErrInvalidIPAddress
 	}
-
-	// If api gives back status code >399, report error to user
-	if resp.IsError() {
-		return response, ipbaseError
+	// Plain ipv4 addresses have a single spelling, so the key doesn't need to be formatted again
+	if addr.Is4() {
+		return addr, ipAddress, nil
 	}
+	addr = canonicalAddr(addr)
+	return addr, addr.String(), nil
+}
 
-	return resp.Result().(*ipbaseResponse), nil
+// canonicalAddr unmaps ipv4-mapped ipv6 addresses and strips zones
+func canonicalAddr(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
 }
 
 // New creates a new geofence for the IP address specified.
 // Use "" as the ip address to geofence the machine your application is running on
 // Token comes from https://ipbase.com/
+// The configuration is validated first, returning one of the ErrInvalid errors or ErrMissingToken without a geofence.
 func New(c *Config) (*Geofence, error) {
-	// Create new client for ipbase.com
-	ipbaseClient := resty.New().SetBaseURL(ipBaseBaseURL)
+	err := c.validate()
+	if err != nil {
+		return nil, err
+	}
 
 	// New Geofence object
 	geofence := &Geofence{
-		Config:       *c,
-		ipbaseClient: ipbaseClient,
-		ctx:          context.Background(),
+		Config:   *c,
+		provider: c.Provider,
+		failures: gocache.New(c.NegativeCacheTTL, deleteExpiredFailuresInterval),
+		ctx:      context.Background(),
 	}
+	geofence.center.interfaceAddrs = net.InterfaceAddrs
+	geofence.center.newTicker = newTicker
+	geofence.storeRules(c.rules())
 
-	// Set up redis client if options are provided
-	// else we create a local in-memory cache
-	if c.RedisOptions != nil {
-		c.RedisOptions.TTL = c.CacheTTL
-		if c.CacheTTL < 0 {
-			c.RedisOptions.TTL = 0
-		}
-		geofence.cache = cache.NewRedisCache(c.RedisOptions)
-	} else {
-		geofence.cache = cache.NewMemoryCache(&cache.MemoryOptions{
-			TTL: c.CacheTTL,
+	// Default to ipbase.com
+	if geofence.provider == nil {
+		ipbaseProvider := NewIPBaseProviderWithOptions(c.Token, &IPBaseOptions{
+			HTTPClient: c.HTTPClient,
+			Transport:  c.Transport,
+			BaseURL:    c.BaseURL,
+			Timeout:    c.Timeout,
 		})
+		geofence.provider = ipbaseProvider
+		geofence.ipbaseClient = ipbaseProvider.client
+	}
+
+	// Every attempt of a retried lookup counts against the rate limit
+	if c.RateLimit != nil {
+		geofence.limiter = provider.NewRateLimiter(geofence.provider, c.RateLimit)
+		geofence.provider = geofence.limiter
+	}
+
+	if c.RetryPolicy != nil {
+		geofence.retrier = provider.NewRetrier(geofence.provider, c.RetryPolicy)
+		geofence.provider = geofence.retrier
+	}
+
+	// The breaker wraps retries so a lookup only counts as one failure once every attempt failed
+	if c.CircuitBreaker != nil {
+		geofence.breaker = provider.NewCircuitBreaker(geofence.provider, c.CircuitBreaker)
+		geofence.provider = geofence.breaker
 	}
 
-	// Get current location of specified IP address
-	// If empty string, use public IP of device running this
-	// or use location of the specified IP
-	ipAddressLookupDetails, err := geofence.getIPGeoData(c.IPAddress)
+	geofence.cache, err = newCache(c)
 	if err != nil {
 		return geofence, err
 	}
 
-	// Set the location of our geofence to compare against looked up IP's
-	geofence.Latitude = ipAddressLookupDetails.Data.Location.Latitude
-	geofence.Longitude = ipAddressLookupDetails.Data.Location.Longitude
+	err = geofence.start()
+	if err != nil {
+		// Caches opened from options are closed so the geofence can be created again, Config.Cache is left to its owner
+		if c.Cache == nil {
+			_ = geofence.closeCache()
+		}
+		return geofence, err
+	}
 
 	return geofence, nil
 }
 
+// start locates the center, loads the cache snapshot and starts refreshing the center
+func (g *Geofence) start() error {
+	c := &g.Config
+	switch {
+	case c.Center != nil:
+		g.setCenter(*c.Center)
+	case c.Place != "":
+		coordinates, err := LookupPlace(c.Place)
+		if err != nil {
+			return err
+		}
+		g.setCenter(coordinates)
+	case !c.LazyCenter:
+		err := g.locateCenter()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The snapshot is checked against the geofence, so it's loaded once the center is known
+	err := g.loadCacheSnapshot()
+	if err != nil {
+		return err
+	}
+	g.startCenterRefresh()
+
+	return nil
+}
+
+// newCache sets up a redis, memcached or on-disk cache if options are provided
+// else we create a local in-memory cache
+func newCache(c *Config) (cache.Cache, error) {
+	switch {
+	case c.Cache != nil:
+		return c.Cache, nil
+	case c.RedisOptions != nil:
+		c.RedisOptions.TTL = c.CacheTTL
+		if c.CacheTTL < 0 {
+			c.RedisOptions.TTL = 0
+		}
+		// Without a prefix the cache can't be flushed, exported or saved to a snapshot
+		if c.RedisOptions.KeyPrefix == "" {
+			c.RedisOptions.KeyPrefix = cache.DefaultRedisKeyPrefix
+		}
+		c.RedisOptions.SoftTTL = c.CacheSoftTTL
+		c.RedisOptions.StaleTTL = c.StaleCacheTTL
+		return cache.NewRedisCache(c.RedisOptions), nil
+	case c.MemcachedOptions != nil:
+		c.MemcachedOptions.TTL = c.CacheTTL
+		c.MemcachedOptions.SoftTTL = c.CacheSoftTTL
+		c.MemcachedOptions.StaleTTL = c.StaleCacheTTL
+		return cache.NewMemcachedCache(c.MemcachedOptions)
+	case c.DiskOptions != nil:
+		c.DiskOptions.TTL = c.CacheTTL
+		c.DiskOptions.SoftTTL = c.CacheSoftTTL
+		c.DiskOptions.StaleTTL = c.StaleCacheTTL
+		return cache.NewDiskCache(c.DiskOptions)
+	default:
+		return cache.NewMemoryCache(&cache.MemoryOptions{
+			TTL:      c.CacheTTL,
+			SoftTTL:  c.CacheSoftTTL,
+			StaleTTL: c.StaleCacheTTL,
+		}), nil
+	}
+}
+
 // IsIPAddressNear returns true if the specified address is within proximity
 func (g *Geofence) IsIPAddressNear(ipAddress string) (bool, error) {
-	// Ensure IP is valid first
-	err := validateIPAddress(ipAddress)
+	decision, err := g.Check(ipAddress)
 	if err != nil {
 		return false, err
 	}
+	return decision.IsIPAddressNear, nil
+}
 
-	if g.Config.AllowPrivateIPAddresses {
-		ip := net.ParseIP(ipAddress)
-		if ip.IsPrivate() || ip.IsLoopback() {
-			return true, nil
-		}
+// IsAddrNear works like IsIPAddressNear for an already parsed address
+func (g *Geofence) IsAddrNear(addr netip.Addr) (bool, error) {
+	decision, err := g.CheckAddr(addr)
+	if err != nil {
+		return false, err
+	}
+	return decision.IsIPAddressNear, nil
+}
+
+// Check works like IsIPAddressNear but describes how the answer was reached.
+// The returned decision is never nil, its IPAddress is the canonical form of the address.
+func (g *Geofence) Check(ipAddress string) (*Decision, error) {
+	// Ensure IP is valid first
+	addr, key, err := parseIPAddress(ipAddress)
+	if err != nil {
+		return &Decision{IPAddress: ipAddress}, err
+	}
+	return g.check(addr, key)
+}
+
+// CheckAddr works like Check for an already parsed address
+func (g *Geofence) CheckAddr(addr netip.Addr) (*Decision, error) {
+	if !addr.IsValid() {
+		return &Decision{}, ErrInvalidIPAddress
+	}
+	addr = canonicalAddr(addr)
+	return g.check(addr, addr.String())
+}
+
+// check decides for a canonical address, key is its string form
+func (g *Geofence) check(addr netip.Addr, ipAddress string) (*Decision, error) {
+	decision := &Decision{IPAddress: ipAddress}
+	// The whole check uses the rules it started with, even if they're reloaded meanwhile
+	rules := g.currentRules()
+
+	if decided, err := g.decideWithoutLookup(decision, addr, rules); decided {
+		return decision, err
 	}
 
 	// Check if ipaddress has been looked up before and is in cache
-	isIPAddressNear, found, err := g.cache.Get(g.ctx, ipAddress)
+	entry, found, err := g.cache.Get(g.ctx, ipAddress)
 	if err != nil {
-		return false, err
+		return decision, err
+	}
+	// Results decided against another center or radius are misses, they're replaced by the lookup
+	if found && !g.decidedBy(entry, rules) {
+		entry, found = nil, false
 	}
 
-	if found {
-		return isIPAddressNear, nil
+	if found && !entry.Expired {
+		// Serve stale results right away and refresh them for the next caller
+		if entry.Stale {
+			g.refreshInBackground(ipAddress)
+		}
+		decision.Source = SourceCache
+		decision.IsIPAddressNear = entry.IsIPAddressNear
+		decision.Proximity = Proximity(entry.Proximity)
+		return decision, nil
 	}
 
 	// If not in cache, lookup IP and compare
-	ipAddressLookupDetails, err := g.getIPGeoData(ipAddress)
+	decision, err = g.lookup(ipAddress, rules)
 	if err != nil {
-		return false, err
+		var lookupErr *lookupError
+		if errors.As(err, &lookupErr) {
+			return g.onLookupFailure(decision, lookupErr.err, entry, rules)
+		}
+		return decision, err
+	}
+
+	return decision, nil
+}
+
+// decideWithoutLookup decides overridden and special addresses, which are never looked up or cached
+func (g *Geofence) decideWithoutLookup(decision *Decision, addr netip.Addr, rules *Rules) (bool, error) {
+	if rules.Overrides != nil {
+		if override, ok := rules.Overrides.Match(addr); ok {
+			return true, g.applyOverride(decision, override, rules)
+		}
 	}
+	return g.applySpecialAddressPolicy(decision, addr, rules)
+}
 
-	// Format our IP coordinates and the clients
-	currentCoordinates := geo.NewCoordinatesFromDegrees(g.Latitude, g.Longitude)
-	clientCoordinates := geo.NewCoordinatesFromDegrees(ipAddressLookupDetails.Data.Location.Latitude, ipAddressLookupDetails.Data.Location.Longitude)
+// lookupError wraps errors from fetching geolocation data so they can be told apart from cache errors
+type lookupError struct {
+	err error
+}
 
-	// Get distance in kilometers
-	distance := currentCoordinates.Distance(clientCoordinates)
+func (e *lookupError) Error() string {
+	return e.err.Error()
+}
 
-	// Compare coordinates
-	// Distance must be less than or equal to the configured radius to be near
-	isNear := distance <= g.Config.Radius
+func (e *lookupError) Unwrap() error {
+	return e.err
+}
+
+// lookup fetches the location of an ip address, compares it to the geofence and caches the result
+func (g *Geofence) lookup(ipAddress string, rules *Rules) (*Decision, error) {
+	decision := &Decision{
+		IPAddress: ipAddress,
+		Source:    SourceLookup,
+	}
 
-	err = g.cache.Set(g.ctx, ipAddress, isNear)
+	// Don't hit the api again for addresses that recently failed to be looked up
+	if cachedErr, failed := g.failures.Get(ipAddress); failed {
+		decision.Source = SourceNegativeCache
+		return decision, &lookupError{err: cachedErr.(error)}
+	}
+
+	// Without a center there's nothing to compare to, so don't spend a lookup
+	if err := g.ensureCenter(); err != nil {
+		return decision, &lookupError{err: err}
+	}
+
+	location, err := g.provider.Lookup(g.ctx, ipAddress)
 	if err != nil {
-		return false, err
+		if g.Config.NegativeCacheTTL > 0 && isAddressFailure(err) {
+			g.failures.Set(ipAddress, err, g.Config.NegativeCacheTTL)
+		}
+		var disagreementErr *provider.DisagreementError
+		if errors.As(err, &disagreementErr) {
+			decision.Agreement = disagreementErr.Agreement
+		}
+		return decision, &lookupError{err: err}
+	}
+	decision.Agreement = location.Agreement
+	decision.Attempts = location.Attempts
+	if location.AccuracyRadius == 0 && rules.DefaultAccuracyRadius > 0 {
+		// Copied since providers may share the locations they return
+		assumed := *location
+		assumed.AccuracyRadius = rules.Unit.ToKilometers(rules.DefaultAccuracyRadius)
+		location = &assumed
+	}
+	err = g.compare(decision, location, rules)
+	if err != nil {
+		return decision, &lookupError{err: err}
+	}
+
+	err = g.cache.Set(g.ctx, ipAddress, &cache.Entry{
+		IsIPAddressNear: decision.IsIPAddressNear,
+		Proximity:       string(decision.Proximity),
+		Fingerprint:     decision.fingerprint,
+	})
+	if err != nil {
+		return decision, err
+	}
+
+	return decision, nil
+}
+
+// isAddressFailure reports whether a lookup failed because of the address rather than the
+// circuit breaker or rate limiter refusing to make the request
+func isAddressFailure(err error) bool {
+	return !errors.Is(err, provider.ErrCircuitOpen) &&
+		!errors.Is(err, provider.ErrRateLimited) &&
+		!errors.Is(err, provider.ErrQuotaExhausted)
+}
+
+// compare measures the distance from the geofence to the location and decides if it's near
+func (g *Geofence) compare(decision *Decision, location *provider.Location, rules *Rules) error {
+	err := g.ensureCenter()
+	if err != nil {
+		return err
+	}
+
+	// Get distance in the unit of the radius
+	center := g.Center()
+	decision.fingerprint = rules.fingerprintFor(center)
+	distance := center.Distance(Coordinates{Latitude: location.Latitude, Longitude: location.Longitude},
+		rules.DistanceFormula, rules.Unit)
+
+	// Compare the accuracy radius around the coordinates to the geofence,
+	// uncertain locations are decided by the configured policy
+	decision.Location = location
+	decision.Distance = distance
+	decision.Proximity = proximity(distance, rules.Unit.FromKilometers(location.AccuracyRadius), rules.Radius)
+	decision.IsIPAddressNear = rules.UncertainPolicy.near(decision.Proximity, distance, rules.Radius)
+	return nil
+}
+
+// refreshInBackground looks up an ip address again without blocking the caller.
+// Only one refresh per ip address runs at a time.
+func (g *Geofence) refreshInBackground(ipAddress string) {
+	if _, inFlight := g.refreshing.LoadOrStore(ipAddress, struct{}{}); inFlight {
+		return
+	}
+
+	go func() {
+		defer g.refreshing.Delete(ipAddress)
+		// Failures are remembered by the negative cache and the stale entry stays until it expires
+		_, _ = g.lookup(ipAddress, g.currentRules())
+	}()
+}
+
+// onLookupFailure applies the configured failure policy to a failed lookup.
+// stale is the expired cache entry for the ip address, if any.
+func (g *Geofence) onLookupFailure(decision *Decision, err error, stale *cache.Entry, rules *Rules) (*Decision, error) {
+	decision.Err = err
+	switch rules.FailurePolicy {
+	case FailurePolicyOpen:
+		decision.Source = SourceFailurePolicy
+		decision.IsIPAddressNear = true
+		return decision, nil
+	case FailurePolicyClosed:
+		decision.Source = SourceFailurePolicy
+		return decision, nil
+	case FailurePolicyServeStale:
+		if stale != nil {
+			decision.Source = SourceFailurePolicy
+			decision.IsIPAddressNear = stale.IsIPAddressNear
+			decision.Proximity = Proximity(stale.Proximity)
+			return decision, nil
+		}
+	}
+	return decision, err
+}
+
+// DeleteCachedIPAddress removes the cached result and any remembered lookup failure for an ip address
+func (g *Geofence) DeleteCachedIPAddress(ipAddress string) error {
+	if _, key, err := parseIPAddress(ipAddress); err == nil {
+		ipAddress = key
 	}
+	g.failures.Delete(ipAddress)
+	return g.cache.Delete(g.ctx, ipAddress)
+}
+
+// FlushCache removes all cached results and remembered lookup failures
+func (g *Geofence) FlushCache() error {
+	g.failures.Flush()
+	return g.cache.Flush(g.ctx)
+}
+
+// CacheLen returns the number of cached results
+func (g *Geofence) CacheLen() (int, error) {
+	return g.cache.Len(g.ctx)
+}
+
+// CacheStats returns the hit, miss and eviction counters of the cache
+func (g *Geofence) CacheStats() cache.Stats {
+	return g.cache.Stats()
+}
+
+// RangeCache calls fn for each cached ip address and result until fn returns false
+func (g *Geofence) RangeCache(fn func(ipAddress string, entry *cache.Entry) bool) error {
+	return g.cache.Range(g.ctx, fn)
+}
+
+// RetryStats returns the retry counters of provider lookups, zero if Config.RetryPolicy isn't set
+func (g *Geofence) RetryStats() provider.RetryStats {
+	if g.retrier == nil {
+		return provider.RetryStats{}
+	}
+	return g.retrier.Stats()
+}
 
-	return isNear, nil
+// CircuitState returns the state of the circuit breaker for health checks, always closed if Config.CircuitBreaker isn't set
+func (g *Geofence) CircuitState() provider.State {
+	if g.breaker == nil {
+		return provider.StateClosed
+	}
+	return g.breaker.State()
+}
+
+// QuotaUsage returns how many provider requests were made this month, zero if Config.RateLimit isn't set
+func (g *Geofence) QuotaUsage() (int64, error) {
+	if g.limiter == nil {
+		return 0, nil
+	}
+	return g.limiter.QuotaUsage(g.ctx)
+}
+
+// Close stops refreshing the center, saves the cache snapshot, if configured, and releases the connections held by the cache
+// and the quota counter. The snapshot is skipped for caches that can't list their entries, such as memcached.
+func (g *Geofence) Close() error {
+	g.stopCenterRefresh()
+	err := g.SaveCacheSnapshot()
+	if errors.Is(err, cache.ErrNotSupported) {
+		err = nil
+	}
+	if closeErr := g.closeCache(); err == nil {
+		err = closeErr
+	}
+	if closeErr := g.closeQuotaCounter(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeQuotaCounter closes the quota counter of Config.RateLimit if it holds connections
+func (g *Geofence) closeQuotaCounter() error {
+	if g.Config.RateLimit == nil {
+		return nil
+	}
+	if closer, ok := g.Config.RateLimit.QuotaCounter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// closeCache closes the cache if it holds connections or files
+func (g *Geofence) closeCache() error {
+	if closer, ok := g.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
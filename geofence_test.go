@@ -1,12 +1,20 @@
 package geofence
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/circa10a/go-geofence/cache"
+	"github.com/circa10a/go-geofence/provider"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,37 +23,49 @@ var (
 	endpointStrTemplate = "%s/info?apikey=%s&ip=%s"
 )
 
-func TestValidateIPAddress(t *testing.T) {
+func TestParseIPAddress(t *testing.T) {
 	tests := []struct {
-		expected error
-		input    string
+		expected    error
+		input       string
+		expectedKey string
 	}{
 		{
-			input:    "8.8.8.8",
-			expected: nil,
+			input:       "8.8.8.8",
+			expectedKey: "8.8.8.8",
 		},
 		{
 			input:    "8.8.88",
 			expected: ErrInvalidIPAddress,
 		},
 		{
-			input:    "2001:db8:3333:4444:5555:6666:7777:8888",
-			expected: nil,
+			input:       "2001:db8:3333:4444:5555:6666:7777:8888",
+			expectedKey: "2001:db8:3333:4444:5555:6666:7777:8888",
 		},
 		{
 			input:    "2001:db8:3333:4444:5555:6666:7777:88888",
 			expected: ErrInvalidIPAddress,
 		},
+		{
+			input:       "::ffff:8.8.8.8",
+			expectedKey: "8.8.8.8",
+		},
+		{
+			input:       "2001:DB8:0::1",
+			expectedKey: "2001:db8::1",
+		},
+		{
+			input:       "fe80::1%eth0",
+			expectedKey: "fe80::1",
+		},
 	}
 	for _, test := range tests {
-		actual := validateIPAddress(test.input)
+		_, key, err := parseIPAddress(test.input)
 		if test.expected != nil {
-			assert.ErrorIs(t, actual, test.expected)
-		} else {
-			if !errors.Is(actual, test.expected) {
-				t.Fail()
-			}
+			assert.ErrorIs(t, err, test.expected)
+			continue
 		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedKey, key)
 	}
 }
 
@@ -235,3 +255,733 @@ func TestGeofenceNotNear(t *testing.T) {
 	// Check total calls
 	assert.Equal(t, info[fmt.Sprintf("GET %s", fakeEndpoint)], 1)
 }
+
+func TestGeofenceNegativeCache(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	fakeApiToken := "fakeApiToken"
+	fakeEndpoint := fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, fakeIPAddress)
+
+	// new geofence
+	geofence, _ := New(&Config{
+		IPAddress:        fakeIPAddress,
+		Token:            fakeApiToken,
+		CacheTTL:         7 * (24 * time.Hour), // 1 week
+		NegativeCacheTTL: time.Minute,
+	})
+
+	httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", fakeEndpoint,
+		httpmock.NewJsonResponderOrPanic(500, &IPBaseError{Message: "internal error"}))
+
+	// Both lookups fail, but only the first reaches the api
+	for i := 0; i < 2; i++ {
+		isAddressNearby, err := geofence.IsIPAddressNear(fakeIPAddress)
+		assert.EqualError(t, err, "internal error")
+		assert.False(t, isAddressNearby)
+	}
+
+	// The remembered failure is reported as such
+	decision, err := geofence.Check(fakeIPAddress)
+	assert.EqualError(t, err, "internal error")
+	assert.Equal(t, SourceNegativeCache, decision.Source)
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, info[fmt.Sprintf("GET %s", fakeEndpoint)], 1)
+}
+
+func TestGeofenceFailurePolicy(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	fakeApiToken := "fakeApiToken"
+	fakeEndpoint := fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, fakeIPAddress)
+
+	tests := []struct {
+		stale    *cache.Entry
+		policy   FailurePolicy
+		expected bool
+		err      bool
+	}{
+		{
+			policy:   FailurePolicyError,
+			expected: false,
+			err:      true,
+		},
+		{
+			policy:   FailurePolicyOpen,
+			expected: true,
+		},
+		{
+			policy:   FailurePolicyClosed,
+			expected: false,
+		},
+		// Nothing to serve
+		{
+			policy:   FailurePolicyServeStale,
+			expected: false,
+			err:      true,
+		},
+		// Expired entry retained by StaleCacheTTL
+		{
+			stale: &cache.Entry{
+				StoredAt:        time.Now().Add(-2 * time.Hour),
+				IsIPAddressNear: true,
+			},
+			policy:   FailurePolicyServeStale,
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		geofence, _ := New(&Config{
+			IPAddress:     fakeIPAddress,
+			Token:         fakeApiToken,
+			CacheTTL:      time.Hour,
+			StaleCacheTTL: 24 * time.Hour,
+			FailurePolicy: test.policy,
+		})
+
+		if test.stale != nil {
+			assert.NoError(t, geofence.cache.Set(context.TODO(), fakeIPAddress, test.stale))
+		}
+
+		httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+		httpmock.RegisterResponder("GET", fakeEndpoint,
+			httpmock.NewJsonResponderOrPanic(500, &IPBaseError{Message: "internal error"}))
+
+		isAddressNearby, err := geofence.IsIPAddressNear(fakeIPAddress)
+		if test.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.expected, isAddressNearby)
+
+		info := httpmock.GetCallCountInfo()
+		assert.Equal(t, info[fmt.Sprintf("GET %s", fakeEndpoint)], 1)
+		httpmock.DeactivateAndReset()
+	}
+}
+
+func TestGeofenceStaleWhileRevalidate(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	fakeApiToken := "fakeApiToken"
+	fakeLatitude := 37.751
+	fakeLongitude := -97.822
+	fakeEndpoint := fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, fakeIPAddress)
+
+	// new geofence
+	geofence, _ := New(&Config{
+		IPAddress:    fakeIPAddress,
+		Token:        fakeApiToken,
+		CacheTTL:     time.Hour,
+		CacheSoftTTL: time.Minute,
+	})
+	geofence.Latitude = fakeLatitude
+	geofence.Longitude = fakeLongitude
+
+	// Stale result that no longer matches the api
+	err := geofence.cache.Set(context.TODO(), fakeIPAddress, &cache.Entry{
+		StoredAt:        time.Now().Add(-2 * time.Minute),
+		IsIPAddressNear: false,
+	})
+	assert.NoError(t, err)
+
+	httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	response := &ipbaseResponse{
+		Data: data{
+			IP: fakeIPAddress,
+			Location: location{
+				Latitude:  fakeLatitude,
+				Longitude: fakeLongitude,
+			},
+		},
+	}
+	httpmock.RegisterResponder("GET", fakeEndpoint, httpmock.NewJsonResponderOrPanic(200, response))
+
+	// Stale value is served immediately
+	isAddressNearby, err := geofence.IsIPAddressNear(fakeIPAddress)
+	assert.NoError(t, err)
+	assert.False(t, isAddressNearby)
+
+	// Background refresh updates the cache
+	assert.Eventually(t, func() bool {
+		entry, found, err := geofence.cache.Get(context.TODO(), fakeIPAddress)
+		return err == nil && found && !entry.Stale && entry.IsIPAddressNear
+	}, time.Second, 10*time.Millisecond)
+
+	isAddressNearby, err = geofence.IsIPAddressNear(fakeIPAddress)
+	assert.NoError(t, err)
+	assert.True(t, isAddressNearby)
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, info[fmt.Sprintf("GET %s", fakeEndpoint)], 1)
+}
+
+func TestGeofenceCacheManagement(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	fakeApiToken := "fakeApiToken"
+	fakeEndpoint := fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, fakeIPAddress)
+
+	geofence, _ := New(&Config{
+		IPAddress:        fakeIPAddress,
+		Token:            fakeApiToken,
+		CacheTTL:         time.Hour,
+		NegativeCacheTTL: time.Hour,
+	})
+
+	httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", fakeEndpoint, httpmock.NewJsonResponderOrPanic(200, &ipbaseResponse{}))
+
+	_, err := geofence.IsIPAddressNear(fakeIPAddress)
+	assert.NoError(t, err)
+
+	length, err := geofence.CacheLen()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, length)
+
+	err = geofence.RangeCache(func(ipAddress string, entry *cache.Entry) bool {
+		assert.Equal(t, fakeIPAddress, ipAddress)
+		return true
+	})
+	assert.NoError(t, err)
+
+	// Deleting forces a new lookup
+	assert.NoError(t, geofence.DeleteCachedIPAddress(fakeIPAddress))
+	_, err = geofence.IsIPAddressNear(fakeIPAddress)
+	assert.NoError(t, err)
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, 2, info[fmt.Sprintf("GET %s", fakeEndpoint)])
+
+	// Flushing also forgets failures
+	httpmock.RegisterResponder("GET", fakeEndpoint, httpmock.NewJsonResponderOrPanic(500, &IPBaseError{Message: "internal error"}))
+	assert.NoError(t, geofence.FlushCache())
+	_, err = geofence.IsIPAddressNear(fakeIPAddress)
+	assert.Error(t, err)
+	assert.NoError(t, geofence.FlushCache())
+	_, err = geofence.IsIPAddressNear(fakeIPAddress)
+	assert.Error(t, err)
+
+	info = httpmock.GetCallCountInfo()
+	assert.Equal(t, 2, info[fmt.Sprintf("GET %s", fakeEndpoint)])
+	assert.Equal(t, cache.Stats{Hits: 0, Misses: 4, Evictions: 2}, geofence.CacheStats())
+}
+
+func TestGeofenceRetry(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	fakeApiToken := "fakeApiToken"
+	fakeEndpoint := fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, fakeIPAddress)
+
+	geofence, _ := New(&Config{
+		IPAddress: fakeIPAddress,
+		Token:     fakeApiToken,
+		CacheTTL:  time.Hour,
+		RetryPolicy: &provider.RetryPolicy{
+			InitialBackoff: time.Millisecond,
+		},
+	})
+	geofence.Latitude = 37.751
+	geofence.Longitude = -97.822
+	statsBefore := geofence.RetryStats()
+
+	httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	// Fails once, then succeeds
+	calls := 0
+	httpmock.RegisterResponder("GET", fakeEndpoint,
+		func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return httpmock.NewJsonResponse(503, &IPBaseError{Message: "unavailable"})
+			}
+			return httpmock.NewJsonResponse(200, &ipbaseResponse{
+				Data: data{
+					Location: location{
+						Latitude:  37.751,
+						Longitude: -97.822,
+					},
+				},
+			})
+		})
+
+	decision, err := geofence.Check(fakeIPAddress)
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+	assert.Equal(t, SourceLookup, decision.Source)
+	assert.Equal(t, 2, decision.Attempts)
+	assert.Equal(t, 2, calls)
+
+	stats := geofence.RetryStats()
+	assert.Equal(t, statsBefore.Lookups+1, stats.Lookups)
+	assert.Equal(t, statsBefore.Retries+1, stats.Retries)
+	assert.Equal(t, statsBefore.Failures, stats.Failures)
+
+	// Answered from cache
+	decision, err = geofence.Check(fakeIPAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceCache, decision.Source)
+	assert.Equal(t, 2, calls)
+}
+
+func TestGeofenceCheck(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	fakeApiToken := "fakeApiToken"
+	fakeEndpoint := fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, fakeIPAddress)
+
+	geofence, _ := New(&Config{
+		IPAddress:               fakeIPAddress,
+		Token:                   fakeApiToken,
+		CacheTTL:                time.Hour,
+		FailurePolicy:           FailurePolicyOpen,
+		AllowPrivateIPAddresses: true,
+	})
+
+	httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", fakeEndpoint,
+		httpmock.NewJsonResponderOrPanic(429, &IPBaseError{Message: "rate limited"}))
+
+	decision, err := geofence.Check("192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, SourcePrivate, decision.Source)
+	assert.True(t, decision.IsIPAddressNear)
+
+	decision, err = geofence.Check(fakeIPAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceFailurePolicy, decision.Source)
+	assert.True(t, decision.IsIPAddressNear)
+	var statusErr *provider.StatusError
+	assert.ErrorAs(t, decision.Err, &statusErr)
+	assert.Equal(t, 429, statusErr.StatusCode)
+	assert.EqualError(t, decision.Err, "rate limited")
+
+	decision, err = geofence.Check("8.8.88")
+	assert.ErrorIs(t, err, ErrInvalidIPAddress)
+	assert.NotNil(t, decision)
+	assert.False(t, decision.IsIPAddressNear)
+}
+
+func TestGeofenceCircuitBreaker(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	calls := 0
+	failing := false
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		calls++
+		if failing {
+			return nil, errors.New("connection refused")
+		}
+		return &provider.Location{}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider:         fakeProvider,
+		CacheTTL:         time.Hour,
+		NegativeCacheTTL: time.Hour,
+		FailurePolicy:    FailurePolicyClosed,
+		CircuitBreaker: &provider.BreakerOptions{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Hour,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, provider.StateClosed, geofence.CircuitState())
+
+	// Trips the breaker
+	failing = true
+	_, _ = geofence.Check(fakeIPAddress)
+	assert.Equal(t, provider.StateOpen, geofence.CircuitState())
+	assert.Equal(t, 2, calls)
+
+	// Answered by the failure policy without calling the provider
+	otherIPAddress := "1.1.1.1"
+	decision, err := geofence.Check(otherIPAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceFailurePolicy, decision.Source)
+	assert.ErrorIs(t, decision.Err, provider.ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+
+	// Short circuited addresses aren't remembered as failed
+	_, failed := geofence.failures.Get(otherIPAddress)
+	assert.False(t, failed)
+}
+
+func TestGeofenceRateLimit(t *testing.T) {
+	fakeIPAddress := "8.8.8.8"
+	calls := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		calls++
+		return &provider.Location{}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider:         fakeProvider,
+		CacheTTL:         time.Hour,
+		NegativeCacheTTL: time.Hour,
+		RateLimit: &provider.RateLimitOptions{
+			MonthlyQuota: 2,
+			Exhausted:    provider.ExhaustedFail,
+		},
+	})
+	assert.NoError(t, err)
+
+	// New used the first request
+	isAddressNearby, err := geofence.IsIPAddressNear(fakeIPAddress)
+	assert.NoError(t, err)
+	assert.True(t, isAddressNearby)
+
+	otherIPAddress := "1.1.1.1"
+	_, err = geofence.IsIPAddressNear(otherIPAddress)
+	assert.ErrorIs(t, err, provider.ErrQuotaExhausted)
+	assert.Equal(t, 2, calls)
+
+	// The refused lookup wasn't sent, so it doesn't count
+	usage, err := geofence.QuotaUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), usage)
+
+	// Refused lookups aren't remembered as failed
+	_, failed := geofence.failures.Get(otherIPAddress)
+	assert.False(t, failed)
+}
+
+// closingQuotaCounter counts in memory and records being closed
+type closingQuotaCounter struct {
+	*provider.MemoryQuotaCounter
+	closed int
+}
+
+func (c *closingQuotaCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestGeofenceCloseQuotaCounter(t *testing.T) {
+	counter := &closingQuotaCounter{MemoryQuotaCounter: provider.NewMemoryQuotaCounter()}
+	geofence, err := New(&Config{
+		Provider: provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+			return &provider.Location{}, nil
+		}),
+		RateLimit: &provider.RateLimitOptions{
+			MonthlyQuota: 10,
+			QuotaCounter: counter,
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, geofence.Close())
+	assert.Equal(t, 1, counter.closed)
+}
+
+func TestGeofenceProviderChain(t *testing.T) {
+	unavailable := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return nil, errors.New("connection refused")
+	})
+	secondary := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider: provider.NewChain(
+			provider.ChainLink{Provider: unavailable, Name: "primary"},
+			provider.ChainLink{Provider: secondary, Name: "secondary"},
+		),
+		Radius:   1,
+		CacheTTL: time.Hour,
+	})
+	assert.NoError(t, err)
+
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+	assert.Equal(t, "secondary", decision.Location.Provider)
+}
+
+func TestGeofenceConsensus(t *testing.T) {
+	kansas := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822, Country: "US"}, nil
+	})
+	paris := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		if ipAddress == "" {
+			return kansas(ctx, ipAddress)
+		}
+		return &provider.Location{Latitude: 48.857, Longitude: 2.352, Country: "FR"}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider:      provider.NewConsensus(&provider.ConsensusOptions{MaxSpread: 500}, kansas, paris),
+		Radius:        1,
+		CacheTTL:      time.Hour,
+		FailurePolicy: FailurePolicyClosed,
+	})
+	assert.NoError(t, err)
+
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.False(t, decision.IsIPAddressNear)
+	assert.Equal(t, SourceFailurePolicy, decision.Source)
+	assert.ErrorIs(t, decision.Err, provider.ErrDisagreement)
+	assert.Greater(t, decision.Agreement.Spread, 500.0)
+	assert.Len(t, decision.Agreement.Answers, 2)
+}
+
+func TestGeofenceOverrides(t *testing.T) {
+	lookups := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups++
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	})
+	overrides, err := NewOverrides([]Override{
+		{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Verdict: VerdictDeny},
+		{CIDR: netip.MustParsePrefix("10.1.0.0/16"), Verdict: VerdictAllow, Name: "office"},
+		{CIDR: netip.MustParsePrefix("203.0.113.0/24"), Latitude: 48.8566, Longitude: 2.3522, Country: "FR"},
+		{CIDR: netip.MustParsePrefix("198.51.100.0/24"), Latitude: 37.751, Longitude: -97.822, Country: "US"},
+	})
+	assert.NoError(t, err)
+
+	geofence, err := New(&Config{
+		Provider:                fakeProvider,
+		Overrides:               overrides,
+		Radius:                  1,
+		CacheTTL:                time.Hour,
+		AllowPrivateIPAddresses: true,
+	})
+	assert.NoError(t, err)
+	lookups = 0
+
+	tests := []struct {
+		ipAddress    string
+		expectedName string
+		expectedNear bool
+		hasLocation  bool
+	}{
+		// Overrides take precedence over AllowPrivateIPAddresses
+		{ipAddress: "10.2.3.4", expectedNear: false},
+		{ipAddress: "10.1.2.3", expectedNear: true, expectedName: "office"},
+		{ipAddress: "203.0.113.7", expectedNear: false, hasLocation: true},
+		{ipAddress: "::ffff:198.51.100.1", expectedNear: true, hasLocation: true},
+	}
+	for _, test := range tests {
+		decision, err := geofence.Check(test.ipAddress)
+		assert.NoError(t, err)
+		assert.Equal(t, SourceOverride, decision.Source)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear)
+		assert.Equal(t, test.expectedName, decision.Override.Name)
+		assert.Equal(t, test.hasLocation, decision.Location != nil)
+	}
+	assert.Equal(t, 0, lookups)
+
+	// Addresses outside every override are looked up as usual
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, SourceLookup, decision.Source)
+	assert.Equal(t, 1, lookups)
+}
+
+func TestGeofenceCheckAddr(t *testing.T) {
+	lookups := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups++
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider: fakeProvider,
+		Radius:   1,
+		CacheTTL: time.Hour,
+	})
+	assert.NoError(t, err)
+	lookups = 0
+
+	near, err := geofence.IsAddrNear(netip.MustParseAddr("8.8.8.8"))
+	assert.NoError(t, err)
+	assert.True(t, near)
+
+	// Every spelling of the address shares the cached result
+	decision, err := geofence.CheckAddr(netip.MustParseAddr("::ffff:8.8.8.8"))
+	assert.NoError(t, err)
+	assert.Equal(t, "8.8.8.8", decision.IPAddress)
+	assert.Equal(t, SourceCache, decision.Source)
+
+	decision, err = geofence.Check("::ffff:8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, SourceCache, decision.Source)
+	assert.Equal(t, 1, lookups)
+
+	assert.NoError(t, geofence.DeleteCachedIPAddress("::ffff:8.8.8.8"))
+	cacheLen, err := geofence.CacheLen()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cacheLen)
+
+	_, err = geofence.CheckAddr(netip.Addr{})
+	assert.ErrorIs(t, err, ErrInvalidIPAddress)
+}
+
+func TestGeofenceCenter(t *testing.T) {
+	lookups := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups++
+		return &provider.Location{Latitude: 48.8566, Longitude: 2.3522}, nil
+	})
+
+	tests := []struct {
+		config *Config
+		err    error
+	}{
+		{config: &Config{Center: &Coordinates{Latitude: 48.8566, Longitude: 2.3522}}},
+		{config: &Config{Place: "Paris, FR"}},
+		{config: &Config{Place: "Atlantis"}, err: ErrUnknownPlace},
+	}
+	for _, test := range tests {
+		test.config.Provider = fakeProvider
+		test.config.Radius = 1
+		geofence, err := New(test.config)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, 48.8566, geofence.Latitude)
+		assert.Equal(t, 2.3522, geofence.Longitude)
+	}
+	// The center was never looked up
+	assert.Equal(t, 0, lookups)
+}
+
+func TestGeofenceLazyCenter(t *testing.T) {
+	unavailable := true
+	lookups := map[string]int{}
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups[ipAddress]++
+		if unavailable {
+			return nil, errors.New("connection refused")
+		}
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider:   fakeProvider,
+		Radius:     1,
+		CacheTTL:   time.Hour,
+		LazyCenter: true,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, lookups)
+
+	// The address isn't looked up without a center to compare to
+	_, err = geofence.Check("8.8.8.8")
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"": 1}, lookups)
+
+	// Backing off
+	unavailable = false
+	_, err = geofence.Check("8.8.8.8")
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"": 1}, lookups)
+
+	geofence.center.retryAt = time.Time{}
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+	assert.Equal(t, map[string]int{"": 2, "8.8.8.8": 1}, lookups)
+
+	_, err = geofence.Check("1.1.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"": 2, "8.8.8.8": 1, "1.1.1.1": 1}, lookups)
+}
+
+func TestGeofenceHTTPOptions(t *testing.T) {
+	fakeApiToken := "fakeApiToken"
+	response := &ipbaseResponse{
+		Data: data{
+			Location: location{Latitude: 37.751, Longitude: -97.822},
+		},
+	}
+
+	// The transport receives the requests, without reaching into the client
+	transport := httpmock.NewMockTransport()
+	transport.RegisterResponder("GET", fmt.Sprintf(endpointStrTemplate, "https://ipbase.example.com/v2", fakeApiToken, ""),
+		httpmock.NewJsonResponderOrPanic(200, response))
+	transport.RegisterResponder("GET", fmt.Sprintf(endpointStrTemplate, "https://ipbase.example.com/v2", fakeApiToken, "8.8.8.8"),
+		httpmock.NewJsonResponderOrPanic(200, response))
+
+	httpClient := &http.Client{}
+	geofence, err := NewWithOptions(
+		WithToken(fakeApiToken),
+		WithRadius(1),
+		WithHTTPClient(httpClient),
+		WithTransport(transport),
+		WithBaseURL("https://ipbase.example.com/v2/"),
+		WithTimeout(time.Second),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 37.751, geofence.Latitude)
+
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+	assert.Equal(t, 2, transport.GetTotalCallCount())
+
+	// The client passed in isn't modified
+	assert.Nil(t, httpClient.Transport)
+	assert.Zero(t, httpClient.Timeout)
+
+	// Requests slower than the timeout fail, the server only answers once the client gave up
+	blocked := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-blocked:
+		}
+	}))
+	defer slow.Close()
+	defer close(blocked)
+	_, err = New(&Config{
+		Token:   fakeApiToken,
+		Radius:  1,
+		BaseURL: slow.URL,
+		Timeout: 20 * time.Millisecond,
+	})
+	var urlError *url.Error
+	assert.ErrorAs(t, err, &urlError)
+	assert.True(t, urlError.Timeout())
+}
+
+func TestNewClosesCacheOnError(t *testing.T) {
+	dir := t.TempDir()
+	diskOptions := &cache.DiskOptions{Path: filepath.Join(dir, "geofence.db")}
+	snapshotFile := filepath.Join(dir, "snapshot.jsonl")
+	assert.NoError(t, os.WriteFile(snapshotFile, []byte("not json\n"), 0o600))
+
+	tests := []struct {
+		config *Config
+	}{
+		{
+			config: &Config{Token: "fakeApiToken", Place: "Nowhere, XX", DiskOptions: diskOptions},
+		},
+		{
+			config: &Config{
+				Token:             "fakeApiToken",
+				Center:            &Coordinates{Latitude: 51.5074, Longitude: -0.1278},
+				DiskOptions:       diskOptions,
+				CacheSnapshotFile: snapshotFile,
+			},
+		},
+	}
+	for _, test := range tests {
+		_, err := New(test.config)
+		assert.Error(t, err)
+	}
+
+	// The database file isn't held open by the geofences that failed
+	geofence, err := New(&Config{
+		Token:       "fakeApiToken",
+		Center:      &Coordinates{Latitude: 51.5074, Longitude: -0.1278},
+		DiskOptions: diskOptions,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, geofence.Close())
+}
@@ -0,0 +1,111 @@
+package geofence
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	ipBaseBaseURL      = "https://api.ipbase.com/v2"
+	ipbaseProviderName = "ipbase"
+)
+
+// ipbaseResponse is the json response from ipbase.com
+type ipbaseResponse struct {
+	Data data `json:"data"`
+}
+
+// IPBaseError is the json response when there is an error from ipbase.com
+type IPBaseError struct {
+	Message string `json:"message"`
+}
+
+func (e *IPBaseError) Error() string {
+	return e.Message
+}
+
+// IPBaseProvider looks up ip addresses with https://ipbase.com
+type IPBaseProvider struct {
+	client *resty.Client
+	token  string
+}
+
+// IPBaseOptions configures the requests of an IPBaseProvider. BaseURL defaults to https://api.ipbase.com/v2.
+type IPBaseOptions = provider.HTTPOptions
+
+// NewIPBaseProvider creates a provider for https://ipbase.com using the api token
+func NewIPBaseProvider(token string) *IPBaseProvider {
+	return NewIPBaseProviderWithOptions(token, nil)
+}
+
+// NewIPBaseProviderWithClient works like NewIPBaseProvider but makes requests with httpClient.
+// A nil httpClient uses a default client.
+func NewIPBaseProviderWithClient(token string, httpClient *http.Client) *IPBaseProvider {
+	return NewIPBaseProviderWithOptions(token, &IPBaseOptions{HTTPClient: httpClient})
+}
+
+// NewIPBaseProviderWithOptions works like NewIPBaseProvider but configures its requests with opts.
+// Nil opts use the defaults.
+func NewIPBaseProviderWithOptions(token string, opts *IPBaseOptions) *IPBaseProvider {
+	var o IPBaseOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	client := resty.New()
+	if o.HTTPClient != nil {
+		httpClient := *o.HTTPClient
+		client = resty.NewWithClient(&httpClient)
+	}
+	if o.Transport != nil {
+		client.SetTransport(o.Transport)
+	}
+	if o.Timeout > 0 {
+		client.SetTimeout(o.Timeout)
+	}
+	baseURL := ipBaseBaseURL
+	if o.BaseURL != "" {
+		baseURL = strings.TrimSuffix(o.BaseURL, "/")
+	}
+
+	return &IPBaseProvider{
+		client: client.SetBaseURL(baseURL),
+		token:  token,
+	}
+}
+
+// Lookup fetches geolocation data for specified IP address from https://ipbase.com.
+// Error responses are returned as a *provider.StatusError wrapping an *IPBaseError.
+func (p *IPBaseProvider) Lookup(ctx context.Context, ipAddress string) (*provider.Location, error) {
+	response := &ipbaseResponse{}
+	ipbaseError := &IPBaseError{}
+
+	resp, err := p.client.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetQueryParam("apikey", p.token).
+		SetQueryParam("ip", ipAddress).
+		SetResult(response).
+		SetError(ipbaseError).
+		Get("/info")
+	if err != nil {
+		return nil, err
+	}
+
+	// If api gives back status code >399, report error to user
+	if resp.IsError() {
+		return nil, provider.NewStatusError(ipbaseError, resp.StatusCode(), resp.Header())
+	}
+
+	return &provider.Location{
+		Provider:  ipbaseProviderName,
+		Country:   response.Data.Location.Country.Alpha2,
+		Latitude:  response.Data.Location.Latitude,
+		Longitude: response.Data.Location.Longitude,
+		Attempts:  1,
+	}, nil
+}
@@ -0,0 +1,190 @@
+package geofence
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/circa10a/go-geofence/cache"
+	"github.com/circa10a/go-geofence/provider"
+)
+
+// Option configures a geofence created with NewWithOptions
+type Option func(*Config)
+
+// NewWithOptions creates a new geofence from options, see the Config field of each option.
+// Options are applied in order, so later options override earlier ones.
+func NewWithOptions(opts ...Option) (*Geofence, error) {
+	c := &Config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return New(c)
+}
+
+// WithConfig starts from an existing configuration, later options override its fields
+func WithConfig(config Config) Option {
+	return func(c *Config) {
+		*c = config
+	}
+}
+
+// WithToken sets the ipbase.com api token
+func WithToken(token string) Option {
+	return func(c *Config) {
+		c.Token = token
+	}
+}
+
+// WithIPAddress centers the geofence on the location of an ip address
+func WithIPAddress(ipAddress string) Option {
+	return func(c *Config) {
+		c.IPAddress = ipAddress
+	}
+}
+
+// WithCenter centers the geofence on coordinates
+func WithCenter(latitude, longitude float64) Option {
+	return func(c *Config) {
+		c.Center = &Coordinates{Latitude: latitude, Longitude: longitude}
+	}
+}
+
+// WithPlace centers the geofence on a city, see LookupPlace
+func WithPlace(place string) Option {
+	return func(c *Config) {
+		c.Place = place
+	}
+}
+
+// WithRadius sets the radius of the geofence, in kilometers unless WithUnit is used
+func WithRadius(radius float64) Option {
+	return func(c *Config) {
+		c.Radius = radius
+	}
+}
+
+// WithUnit sets the unit of the radius and distances
+func WithUnit(unit Unit) Option {
+	return func(c *Config) {
+		c.Unit = unit
+	}
+}
+
+// WithDistanceFormula sets how distances from the center are computed
+func WithDistanceFormula(formula DistanceFormula) Option {
+	return func(c *Config) {
+		c.DistanceFormula = formula
+	}
+}
+
+// WithProvider looks up ip addresses with p instead of ipbase.com
+func WithProvider(p provider.Provider) Option {
+	return func(c *Config) {
+		c.Provider = p
+	}
+}
+
+// WithHTTPClient makes the requests of the default ipbase.com provider with client
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Config) {
+		c.HTTPClient = client
+	}
+}
+
+// WithTransport makes the requests of the default ipbase.com provider with transport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Config) {
+		c.Transport = transport
+	}
+}
+
+// WithBaseURL sends the requests of the default ipbase.com provider to baseURL
+func WithBaseURL(baseURL string) Option {
+	return func(c *Config) {
+		c.BaseURL = baseURL
+	}
+}
+
+// WithTimeout bounds each request of the default ipbase.com provider
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Timeout = timeout
+	}
+}
+
+// WithCache stores results in cache
+func WithCache(cache cache.Cache) Option {
+	return func(c *Config) {
+		c.Cache = cache
+	}
+}
+
+// WithCacheTTL sets how long results are cached, -1 caches indefinitely
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.CacheTTL = ttl
+	}
+}
+
+// WithDefaultAccuracyRadius sets the accuracy radius in Config.Unit assumed for locations the provider returns without one
+func WithDefaultAccuracyRadius(radius float64) Option {
+	return func(c *Config) {
+		c.DefaultAccuracyRadius = radius
+	}
+}
+
+// WithUncertainPolicy sets how locations whose accuracy radius overlaps the edge of the geofence are answered
+func WithUncertainPolicy(policy UncertainPolicy) Option {
+	return func(c *Config) {
+		c.UncertainPolicy = policy
+	}
+}
+
+// WithFailurePolicy sets how ip addresses that can't be looked up are answered
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(c *Config) {
+		c.FailurePolicy = policy
+	}
+}
+
+// WithRetryPolicy retries failed lookups
+func WithRetryPolicy(policy *provider.RetryPolicy) Option {
+	return func(c *Config) {
+		c.RetryPolicy = policy
+	}
+}
+
+// WithCircuitBreaker stops calling the provider after consecutive failures
+func WithCircuitBreaker(opts *provider.BreakerOptions) Option {
+	return func(c *Config) {
+		c.CircuitBreaker = opts
+	}
+}
+
+// WithRateLimit limits the requests made to the provider
+func WithRateLimit(opts *provider.RateLimitOptions) Option {
+	return func(c *Config) {
+		c.RateLimit = opts
+	}
+}
+
+// WithOverrides pins ip ranges to a location or verdict
+func WithOverrides(overrides *Overrides) Option {
+	return func(c *Config) {
+		c.Overrides = overrides
+	}
+}
+
+// WithSpecialAddresses decides special-purpose addresses by category
+func WithSpecialAddresses(policies map[AddressCategory]SpecialAddressPolicy) Option {
+	return func(c *Config) {
+		c.SpecialAddresses = policies
+	}
+}
+
+// WithPrivateIPAddresses treats private and loopback addresses as near
+func WithPrivateIPAddresses() Option {
+	return func(c *Config) {
+		c.AllowPrivateIPAddresses = true
+	}
+}
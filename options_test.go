@@ -0,0 +1,50 @@
+package geofence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence/cache"
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	lookups := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups++
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	})
+	memoryCache := cache.NewMemoryCache(&cache.MemoryOptions{TTL: time.Hour})
+
+	geofence, err := NewWithOptions(
+		WithConfig(Config{Radius: 1000}),
+		WithProvider(fakeProvider),
+		WithCenter(37.751, -97.822),
+		WithRadius(1),
+		WithCache(memoryCache),
+		WithFailurePolicy(FailurePolicyClosed),
+		WithPrivateIPAddresses(),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, geofence.Config.Radius)
+	assert.Equal(t, FailurePolicyClosed, geofence.Config.FailurePolicy)
+	assert.True(t, geofence.Config.AllowPrivateIPAddresses)
+
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, decision.IsIPAddressNear)
+	assert.Equal(t, 1, lookups)
+
+	// Results are stored in the given cache
+	cacheLen, err := memoryCache.Len(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cacheLen)
+
+	_, err = NewWithOptions(WithProvider(fakeProvider), WithRadius(-1))
+	assert.ErrorIs(t, err, ErrInvalidRadius)
+
+	_, err = NewWithOptions(WithRadius(1))
+	assert.ErrorIs(t, err, ErrMissingToken)
+}
@@ -0,0 +1,302 @@
+package geofence
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/circa10a/go-geofence/provider"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	overrideProviderName = "override"
+)
+
+// Verdict is what an Override decides for the addresses it covers
+type Verdict string
+
+const (
+	// VerdictLocation places the addresses at the override's coordinates, which are compared to the geofence as usual.
+	// This is the default and needs the latitude and longitude of the override, 0,0 counts as missing.
+	VerdictLocation Verdict = "location"
+	// VerdictAllow always treats the addresses as near
+	VerdictAllow Verdict = "allow"
+	// VerdictDeny always treats the addresses as not near
+	VerdictDeny Verdict = "deny"
+)
+
+// ErrInvalidOverride is returned when an override can't be used
+var ErrInvalidOverride = errors.New("invalid override")
+
+// Override pins a range of ip addresses to a location or verdict, taking precedence over the cache and provider
+type Override struct {
+	// Name describes the override, e.g. "office vpn"
+	Name    string  `json:"name,omitempty" yaml:"name,omitempty"`
+	Verdict Verdict `json:"verdict,omitempty" yaml:"verdict,omitempty"`
+	// Country is the ISO 3166-1 alpha-2 country code reported with VerdictLocation
+	Country string `json:"country,omitempty" yaml:"country,omitempty"`
+	// CIDR is the range of addresses, e.g. 203.0.113.0/24. Single addresses are written as /32 or /128.
+	CIDR      netip.Prefix `json:"cidr" yaml:"cidr"`
+	Latitude  float64      `json:"latitude,omitempty" yaml:"latitude,omitempty"`
+	Longitude float64      `json:"longitude,omitempty" yaml:"longitude,omitempty"`
+}
+
+// Overrides is a table of overrides matched by longest prefix
+type Overrides struct {
+	prefixes map[netip.Prefix]*Override
+	// v4Bits and v6Bits are the prefix lengths in the table, longest first
+	v4Bits []int
+	v6Bits []int
+}
+
+// NewOverrides builds a table from overrides, returning ErrInvalidOverride for ones that can't be used.
+// Ranges may nest, the most specific one matches. The same range can't be listed twice.
+func NewOverrides(overrides []Override) (*Overrides, error) {
+	table := &Overrides{
+		prefixes: map[netip.Prefix]*Override{},
+	}
+	v4Bits, v6Bits := map[int]bool{}, map[int]bool{}
+
+	for i := range overrides {
+		override := overrides[i]
+		if !override.CIDR.IsValid() {
+			return nil, fmt.Errorf("%w %d: missing cidr", ErrInvalidOverride, i+1)
+		}
+		switch override.Verdict {
+		case "":
+			override.Verdict = VerdictLocation
+		case VerdictLocation, VerdictAllow, VerdictDeny:
+		default:
+			return nil, fmt.Errorf("%w %d: unknown verdict %q", ErrInvalidOverride, i+1, override.Verdict)
+		}
+		coordinates := Coordinates{Latitude: override.Latitude, Longitude: override.Longitude}
+		if !coordinates.valid() {
+			return nil, fmt.Errorf("%w %d: invalid coordinates %v,%v", ErrInvalidOverride, i+1, override.Latitude, override.Longitude)
+		}
+		// 0,0 is what's left when the coordinates are missing, so it's not accepted as a location
+		if override.Verdict == VerdictLocation && override.Latitude == 0 && override.Longitude == 0 {
+			return nil, fmt.Errorf("%w %d: verdict location needs latitude and longitude", ErrInvalidOverride, i+1)
+		}
+
+		// Ipv4-mapped ranges match the ipv4 addresses they map
+		prefix := override.CIDR.Masked()
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		if _, exists := table.prefixes[prefix]; exists {
+			return nil, fmt.Errorf("%w %d: duplicate cidr %s", ErrInvalidOverride, i+1, prefix)
+		}
+		table.prefixes[prefix] = &override
+
+		if prefix.Addr().Is4() {
+			v4Bits[prefix.Bits()] = true
+		} else {
+			v6Bits[prefix.Bits()] = true
+		}
+	}
+
+	table.v4Bits = sortedBits(v4Bits)
+	table.v6Bits = sortedBits(v6Bits)
+	return table, nil
+}
+
+// sortedBits returns the prefix lengths longest first
+func sortedBits(bits map[int]bool) []int {
+	sorted := make([]int, 0, len(bits))
+	for b := range bits {
+		sorted = append(sorted, b)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	return sorted
+}
+
+// Match returns the most specific override covering the address
+func (o *Overrides) Match(addr netip.Addr) (*Override, bool) {
+	addr = addr.Unmap().WithZone("")
+	bits := o.v6Bits
+	if addr.Is4() {
+		bits = o.v4Bits
+	}
+
+	for _, b := range bits {
+		prefix, err := addr.Prefix(b)
+		if err != nil {
+			continue
+		}
+		if override, ok := o.prefixes[prefix]; ok {
+			return override, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of overrides in the table
+func (o *Overrides) Len() int {
+	return len(o.prefixes)
+}
+
+// List returns the overrides in the table ordered by range
+func (o *Overrides) List() []Override {
+	prefixes := make([]netip.Prefix, 0, len(o.prefixes))
+	for prefix := range o.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	overrides := make([]Override, len(prefixes))
+	for i, prefix := range prefixes {
+		overrides[i] = *o.prefixes[prefix]
+	}
+	return overrides
+}
+
+// LoadOverridesFile loads overrides from a .yaml, .yml, .json or .csv file
+func LoadOverridesFile(path string) (*Overrides, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadOverridesYAML(f)
+	case ".json":
+		return LoadOverridesJSON(f)
+	case ".csv":
+		return LoadOverridesCSV(f)
+	default:
+		return nil, fmt.Errorf("overrides file %s: unknown format, expected .yaml, .yml, .json or .csv", path)
+	}
+}
+
+// LoadOverridesYAML loads a yaml list of overrides
+func LoadOverridesYAML(r io.Reader) (*Overrides, error) {
+	var overrides []Override
+	if err := yaml.NewDecoder(r).Decode(&overrides); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOverride, err)
+	}
+	return NewOverrides(overrides)
+}
+
+// LoadOverridesJSON loads a json array of overrides
+func LoadOverridesJSON(r io.Reader) (*Overrides, error) {
+	var overrides []Override
+	if err := json.NewDecoder(r).Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOverride, err)
+	}
+	return NewOverrides(overrides)
+}
+
+// overridesCSVHeader are the columns of an overrides csv file, all but cidr are optional
+var overridesCSVHeader = []string{"cidr", "verdict", "latitude", "longitude", "country", "name"}
+
+// LoadOverridesCSV loads overrides from csv with the columns cidr, verdict, latitude, longitude, country and name.
+// Trailing columns can be left out and a header row is skipped.
+func LoadOverridesCSV(r io.Reader) (*Overrides, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var overrides []Override
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOverride, err)
+		}
+		if line == 1 && strings.EqualFold(row[0], overridesCSVHeader[0]) {
+			continue
+		}
+
+		override, err := parseOverrideCSVRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidOverride, line, err)
+		}
+		overrides = append(overrides, override)
+	}
+	return NewOverrides(overrides)
+}
+
+func parseOverrideCSVRow(row []string) (Override, error) {
+	if len(row) > len(overridesCSVHeader) {
+		return Override{}, fmt.Errorf("expected at most %d columns, got %d", len(overridesCSVHeader), len(row))
+	}
+	column := func(i int) string {
+		if i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	override := Override{
+		Verdict: Verdict(column(1)),
+		Country: column(4),
+		Name:    column(5),
+	}
+
+	var err error
+	override.CIDR, err = netip.ParsePrefix(column(0))
+	if err != nil {
+		return Override{}, err
+	}
+	lat, lon := column(2), column(3)
+	if (lat == "") != (lon == "") {
+		return Override{}, errors.New("latitude and longitude must be set together")
+	}
+	if lat != "" {
+		override.Latitude, err = strconv.ParseFloat(lat, 64)
+		if err != nil {
+			return Override{}, err
+		}
+	}
+	if lon != "" {
+		override.Longitude, err = strconv.ParseFloat(lon, 64)
+		if err != nil {
+			return Override{}, err
+		}
+	}
+	return override, nil
+}
+
+// applyOverride decides for an address covered by an override
+func (g *Geofence) applyOverride(decision *Decision, override *Override, rules *Rules) error {
+	decision.Source = SourceOverride
+	decision.Override = override
+	return g.applyVerdict(decision, override.Verdict, rules, &provider.Location{
+		Provider:  overrideProviderName,
+		Country:   override.Country,
+		Latitude:  override.Latitude,
+		Longitude: override.Longitude,
+	})
+}
+
+// applyVerdict decides near or not near, comparing the location to the geofence for VerdictLocation
+func (g *Geofence) applyVerdict(decision *Decision, verdict Verdict, rules *Rules, location *provider.Location) error {
+	switch verdict {
+	case VerdictAllow:
+		decision.IsIPAddressNear = true
+	case VerdictDeny:
+		decision.IsIPAddressNear = false
+	default:
+		return g.compare(decision, location, rules)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package geofence
+
+import (
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverridesMatch(t *testing.T) {
+	overrides, err := NewOverrides([]Override{
+		{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Name: "private", Latitude: 48.8566, Longitude: 2.3522},
+		{CIDR: netip.MustParsePrefix("10.1.0.0/16"), Name: "office", Latitude: 48.8566, Longitude: 2.3522},
+		{CIDR: netip.MustParsePrefix("10.1.2.3/32"), Name: "host", Latitude: 48.8566, Longitude: 2.3522},
+		{CIDR: netip.MustParsePrefix("2001:db8::/32"), Name: "docs", Latitude: 48.8566, Longitude: 2.3522},
+		{CIDR: netip.MustParsePrefix("::ffff:192.0.2.0/120"), Name: "mapped", Latitude: 48.8566, Longitude: 2.3522},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, overrides.Len())
+
+	tests := []struct {
+		ipAddress    string
+		expectedName string
+		found        bool
+	}{
+		{ipAddress: "10.200.0.1", expectedName: "private", found: true},
+		{ipAddress: "10.1.200.1", expectedName: "office", found: true},
+		{ipAddress: "10.1.2.3", expectedName: "host", found: true},
+		{ipAddress: "::ffff:10.1.2.3", expectedName: "host", found: true},
+		{ipAddress: "2001:db8::1", expectedName: "docs", found: true},
+		{ipAddress: "192.0.2.1", expectedName: "mapped", found: true},
+		{ipAddress: "11.0.0.1"},
+		{ipAddress: "2001:db9::1"},
+	}
+	for _, test := range tests {
+		override, found := overrides.Match(netip.MustParseAddr(test.ipAddress))
+		assert.Equal(t, test.found, found, test.ipAddress)
+		if test.found {
+			assert.Equal(t, test.expectedName, override.Name, test.ipAddress)
+			assert.Equal(t, VerdictLocation, override.Verdict)
+		}
+	}
+}
+
+func TestNewOverridesInvalid(t *testing.T) {
+	tests := [][]Override{
+		{{}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Verdict: "maybe"}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Verdict: VerdictAllow}, {CIDR: netip.MustParsePrefix("10.1.0.0/8"), Verdict: VerdictAllow}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8")}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Verdict: VerdictLocation}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Latitude: 91, Longitude: 2.3522}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Latitude: 48.8566, Longitude: -181}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Latitude: math.NaN(), Longitude: 2.3522}},
+		{{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Verdict: VerdictDeny, Latitude: 91}},
+	}
+	for _, test := range tests {
+		_, err := NewOverrides(test)
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	tests := []struct {
+		load func(string) (*Overrides, error)
+		data string
+	}{
+		{
+			load: func(data string) (*Overrides, error) { return LoadOverridesYAML(strings.NewReader(data)) },
+			data: `
+- cidr: 203.0.113.0/24
+  name: paris office
+  country: FR
+  latitude: 48.8566
+  longitude: 2.3522
+- cidr: 198.51.100.0/24
+  verdict: deny
+`,
+		},
+		{
+			load: func(data string) (*Overrides, error) { return LoadOverridesJSON(strings.NewReader(data)) },
+			data: `[
+				{"cidr": "203.0.113.0/24", "name": "paris office", "country": "FR", "latitude": 48.8566, "longitude": 2.3522},
+				{"cidr": "198.51.100.0/24", "verdict": "deny"}
+			]`,
+		},
+		{
+			load: func(data string) (*Overrides, error) { return LoadOverridesCSV(strings.NewReader(data)) },
+			data: "cidr,verdict,latitude,longitude,country,name\n" +
+				"# offices\n" +
+				"203.0.113.0/24,,48.8566,2.3522,FR,paris office\n" +
+				"198.51.100.0/24,deny\n",
+		},
+	}
+	for _, test := range tests {
+		overrides, err := test.load(test.data)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, overrides.Len())
+
+		override, found := overrides.Match(netip.MustParseAddr("203.0.113.7"))
+		assert.True(t, found)
+		assert.Equal(t, Override{
+			CIDR:      netip.MustParsePrefix("203.0.113.0/24"),
+			Name:      "paris office",
+			Verdict:   VerdictLocation,
+			Country:   "FR",
+			Latitude:  48.8566,
+			Longitude: 2.3522,
+		}, *override)
+
+		override, found = overrides.Match(netip.MustParseAddr("198.51.100.7"))
+		assert.True(t, found)
+		assert.Equal(t, VerdictDeny, override.Verdict)
+	}
+}
+
+func TestLoadOverridesCSVInvalid(t *testing.T) {
+	tests := []string{
+		"not a cidr\n",
+		"10.0.0.0/8,location,north\n",
+		"10.0.0.0/8,location,1,2,US,name,extra\n",
+		// Without coordinates the location would be 0,0
+		"10.0.0.0/8\n",
+		"10.0.0.0/8,location\n",
+		"10.0.0.0/8,,48.8566\n",
+		"10.0.0.0/8,,,2.3522\n",
+		"10.0.0.0/8,,91,2.3522\n",
+	}
+	for _, test := range tests {
+		_, err := LoadOverridesCSV(strings.NewReader(test))
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+	}
+}
+
+func TestLoadOverridesFile(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "overrides.csv")
+	assert.NoError(t, os.WriteFile(csvFile, []byte("10.0.0.0/8,allow\n"), 0o600))
+	overrides, err := LoadOverridesFile(csvFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, overrides.Len())
+
+	txtFile := filepath.Join(dir, "overrides.txt")
+	assert.NoError(t, os.WriteFile(txtFile, []byte("10.0.0.0/8,allow\n"), 0o600))
+	_, err = LoadOverridesFile(txtFile)
+	assert.Error(t, err)
+
+	_, err = LoadOverridesFile(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,73 @@
+package geofence
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownPlace is returned when a place name isn't in the embedded table of places
+var ErrUnknownPlace = errors.New("unknown place")
+
+// Coordinates are a latitude and longitude in degrees
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+//go:embed places.csv
+var placesCSV string
+
+var (
+	placesOnce sync.Once
+	// places maps lowercase "name" and "name, country" to coordinates
+	places map[string]Coordinates
+)
+
+// loadPlaces parses the embedded table of places.
+// Places are listed most populous first, so a name without a country resolves to the largest place.
+func loadPlaces() {
+	places = map[string]Coordinates{}
+	reader := csv.NewReader(strings.NewReader(placesCSV))
+	reader.Comment = '#'
+	rows, err := reader.ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("geofence: invalid embedded places: %s", err))
+	}
+
+	for _, row := range rows {
+		latitude, latErr := strconv.ParseFloat(row[2], 64)
+		longitude, lonErr := strconv.ParseFloat(row[3], 64)
+		if latErr != nil || lonErr != nil {
+			panic(fmt.Sprintf("geofence: invalid embedded place %s", row[0]))
+		}
+		coordinates := Coordinates{Latitude: latitude, Longitude: longitude}
+
+		name := strings.ToLower(row[0])
+		if _, exists := places[name]; !exists {
+			places[name] = coordinates
+		}
+		places[name+", "+strings.ToLower(row[1])] = coordinates
+	}
+}
+
+// LookupPlace resolves the name of a major city offline, e.g. "Paris" or "Paris, FR" with an ISO 3166-1 alpha-2 country code.
+// Without a country the most populous place of that name is used.
+func LookupPlace(name string) (Coordinates, error) {
+	placesOnce.Do(loadPlaces)
+
+	key := strings.ToLower(strings.TrimSpace(name))
+	if city, country, found := strings.Cut(key, ","); found {
+		key = strings.TrimSpace(city) + ", " + strings.TrimSpace(country)
+	}
+
+	coordinates, ok := places[key]
+	if !ok {
+		return Coordinates{}, fmt.Errorf("%w: %q", ErrUnknownPlace, name)
+	}
+	return coordinates, nil
+}
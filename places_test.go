@@ -0,0 +1,32 @@
+package geofence
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupPlace(t *testing.T) {
+	tests := []struct {
+		err      error
+		name     string
+		expected Coordinates
+	}{
+		{name: "Paris", expected: Coordinates{Latitude: 48.8566, Longitude: 2.3522}},
+		{name: "  paris, fr ", expected: Coordinates{Latitude: 48.8566, Longitude: 2.3522}},
+		{name: "Paris,US", expected: Coordinates{Latitude: 33.6609, Longitude: -95.5555}},
+		{name: "Sao Paulo, BR", expected: Coordinates{Latitude: -23.5505, Longitude: -46.6333}},
+		{name: "Paris, DE", err: ErrUnknownPlace},
+		{name: "Atlantis", err: ErrUnknownPlace},
+		{name: "", err: ErrUnknownPlace},
+	}
+	for _, test := range tests {
+		coordinates, err := LookupPlace(test.name)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, test.name)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, coordinates, test.name)
+	}
+}
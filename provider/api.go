@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// APIError is an error message returned by a geolocation api
+type APIError struct {
+	Provider string
+	Message  string
+}
+
+func (e *APIError) Error() string {
+	return e.Provider + ": " + e.Message
+}
+
+// HTTPOptions configures the requests of a provider for a geolocation api
+type HTTPOptions struct {
+	// HTTPClient makes the requests. It's copied, so the client passed in isn't modified. Defaults to a new client.
+	HTTPClient *http.Client
+	// Transport replaces the transport of HTTPClient, e.g. to route requests through a proxy or add client certificates.
+	// The default transport uses the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Transport http.RoundTripper
+	// BaseURL of the api. Defaults to the public endpoint of the provider.
+	BaseURL string
+	// Timeout bounds each request, including reading the response. Overrides the timeout of HTTPClient if > 0.
+	Timeout time.Duration
+}
+
+// newAPIClient creates a resty client for a json geolocation api at baseURL, unless opts sets another one.
+// Nil opts use the defaults.
+func newAPIClient(baseURL string, opts *HTTPOptions) *resty.Client {
+	var o HTTPOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	client := resty.New()
+	if o.HTTPClient != nil {
+		httpClient := *o.HTTPClient
+		client = resty.NewWithClient(&httpClient)
+	}
+	if o.Transport != nil {
+		client.SetTransport(o.Transport)
+	}
+	if o.Timeout > 0 {
+		client.SetTimeout(o.Timeout)
+	}
+	if o.BaseURL != "" {
+		baseURL = strings.TrimSuffix(o.BaseURL, "/")
+	}
+
+	return client.
+		SetBaseURL(baseURL).
+		SetHeader("Accept", "application/json")
+}
+
+// apiError wraps an error message from an api with the status code of the response
+func apiError(providerName, message string, resp *resty.Response) error {
+	if message == "" {
+		message = http.StatusText(resp.StatusCode())
+	}
+	return NewStatusError(&APIError{Provider: providerName, Message: message}, resp.StatusCode(), resp.Header())
+}
@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerHalfOpenProbes   = 1
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker while the provider is considered down
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State is the state of a CircuitBreaker
+type State int
+
+const (
+	// StateClosed lets lookups through
+	StateClosed State = iota
+	// StateOpen fails lookups with ErrCircuitOpen without calling the provider
+	StateOpen
+	// StateHalfOpen lets a limited number of probe lookups through to check if the provider recovered
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerOptions configures a CircuitBreaker
+type BreakerOptions struct {
+	// OnStateChange is called after the breaker changes state
+	OnStateChange func(from, to State)
+	// FailureThreshold is how many consecutive failed lookups open the breaker. Defaults to 5.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing the provider. Defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is how many probe lookups must succeed to close the breaker. Defaults to 1.
+	// Lookups beyond this while half-open fail with ErrCircuitOpen.
+	HalfOpenProbes int
+}
+
+// CircuitBreaker is a Provider that stops calling another Provider after consecutive failures.
+// Only errors suggesting the provider is unavailable count as failures:
+// connection errors, timeouts and 429 or 5xx status codes. Misses, disagreements
+// and lookups refused by a RateLimiter don't count.
+type CircuitBreaker struct {
+	provider Provider
+	// now is replaced in tests
+	now      func() time.Time
+	openedAt time.Time
+	opts     BreakerOptions
+	mu       sync.Mutex
+	state    State
+	failures int
+	probes   int
+	passed   int
+	// generation changes with every state change so outcomes of lookups started in an earlier state are ignored
+	generation uint64
+}
+
+// NewCircuitBreaker wraps p with a circuit breaker configured by opts.
+func NewCircuitBreaker(p Provider, opts *BreakerOptions) *CircuitBreaker {
+	b := &CircuitBreaker{
+		provider: p,
+		opts:     *opts,
+		now:      time.Now,
+	}
+	if b.opts.FailureThreshold <= 0 {
+		b.opts.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if b.opts.OpenTimeout <= 0 {
+		b.opts.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if b.opts.HalfOpenProbes <= 0 {
+		b.opts.HalfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+	return b
+}
+
+// Lookup looks up the ip address unless the breaker is open.
+func (b *CircuitBreaker) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	generation, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := b.provider.Lookup(ctx, ipAddress)
+	b.record(generation, err)
+	return location, err
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.opts.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// allow checks if a lookup may go through and returns the generation it belongs to
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	from := b.state
+
+	if b.state == StateOpen {
+		if b.now().Sub(b.openedAt) < b.opts.OpenTimeout {
+			b.mu.Unlock()
+			return 0, ErrCircuitOpen
+		}
+		b.setState(StateHalfOpen)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes+b.passed >= b.opts.HalfOpenProbes {
+			b.mu.Unlock()
+			b.notify(from, StateHalfOpen)
+			return 0, ErrCircuitOpen
+		}
+		b.probes++
+	}
+
+	generation, to := b.generation, b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return generation, nil
+}
+
+// record updates the breaker with the outcome of a lookup
+func (b *CircuitBreaker) record(generation uint64, err error) {
+	b.mu.Lock()
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+	from := b.state
+
+	failed := isUnavailable(err)
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			break
+		}
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		b.probes--
+		if failed {
+			b.setState(StateOpen)
+			break
+		}
+		b.passed++
+		if b.passed >= b.opts.HalfOpenProbes {
+			b.setState(StateClosed)
+		}
+	}
+
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+// setState moves the breaker to state and resets the counters, b.mu must be held
+func (b *CircuitBreaker) setState(state State) {
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probes = 0
+	b.passed = 0
+	if state == StateOpen {
+		b.openedAt = b.now()
+	}
+}
+
+// notify calls OnStateChange if the state changed, b.mu must not be held
+func (b *CircuitBreaker) notify(from, to State) {
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
+
+// isUnavailable reports whether err suggests the provider is down rather than the request being bad
+func isUnavailable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrDisagreement),
+		errors.Is(err, ErrRateLimited),
+		errors.Is(err, ErrQuotaExhausted):
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
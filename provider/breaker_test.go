@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var lookupErr error
+	calls := 0
+	p := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		calls++
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		return &Location{}, nil
+	})
+
+	var transitions []string
+	now := time.Unix(1700000000, 0)
+	breaker := NewCircuitBreaker(p, &BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		HalfOpenProbes:   2,
+		OnStateChange: func(from, to State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	breaker.now = func() time.Time { return now }
+	ctx := context.TODO()
+
+	// Client errors don't count
+	lookupErr = &StatusError{Err: errors.New("bad request"), StatusCode: http.StatusBadRequest}
+	for i := 0; i < 3; i++ {
+		_, _ = breaker.Lookup(ctx, "8.8.8.8")
+	}
+	assert.Equal(t, StateClosed, breaker.State())
+
+	// A success resets the consecutive failures
+	lookupErr = errors.New("connection refused")
+	_, _ = breaker.Lookup(ctx, "8.8.8.8")
+	lookupErr = nil
+	_, _ = breaker.Lookup(ctx, "8.8.8.8")
+	lookupErr = &StatusError{Err: errors.New("unavailable"), StatusCode: http.StatusServiceUnavailable}
+	_, _ = breaker.Lookup(ctx, "8.8.8.8")
+	assert.Equal(t, StateClosed, breaker.State())
+
+	// Opens on the threshold
+	_, _ = breaker.Lookup(ctx, "8.8.8.8")
+	assert.Equal(t, StateOpen, breaker.State())
+
+	// Short circuits while open
+	calls = 0
+	_, err := breaker.Lookup(ctx, "8.8.8.8")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 0, calls)
+
+	// A failed probe opens it again
+	now = now.Add(time.Minute)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	_, err = breaker.Lookup(ctx, "8.8.8.8")
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, StateOpen, breaker.State())
+
+	// Closes once enough probes succeed
+	now = now.Add(time.Minute)
+	lookupErr = nil
+	_, err = breaker.Lookup(ctx, "8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	_, err = breaker.Lookup(ctx, "8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, StateClosed, breaker.State())
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, transitions)
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	failing := true
+	p := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		if failing {
+			return nil, errors.New("connection refused")
+		}
+		started <- struct{}{}
+		<-release
+		return &Location{}, nil
+	})
+
+	now := time.Unix(1700000000, 0)
+	breaker := NewCircuitBreaker(p, &BreakerOptions{FailureThreshold: 1})
+	breaker.now = func() time.Time { return now }
+
+	_, _ = breaker.Lookup(context.TODO(), "8.8.8.8")
+	assert.Equal(t, StateOpen, breaker.State())
+
+	// Only one probe is let through while half-open
+	now = now.Add(defaultBreakerOpenTimeout)
+	failing = false
+	done := make(chan error)
+	go func() {
+		_, err := breaker.Lookup(context.TODO(), "8.8.8.8")
+		done <- err
+	}()
+	<-started
+
+	_, err := breaker.Lookup(context.TODO(), "8.8.8.8")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, StateClosed, breaker.State())
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ChainLink is a provider in a Chain
+type ChainLink struct {
+	Provider Provider
+	// Name is recorded in Location.Provider when this link answers. Defaults to the name set by the provider.
+	Name string
+	// Timeout bounds the lookup of this link. The chain moves on to the next link when it expires. Disabled if <= 0.
+	Timeout time.Duration
+	// MaxAccuracyRadius in kilometers. Answers with a larger accuracy radius are low confidence
+	// and the next link is tried. Answers without an accuracy radius are accepted. Disabled if <= 0.
+	MaxAccuracyRadius float64
+}
+
+// Chain is a Provider that tries each of its links in order until one answers with confidence.
+// A link is skipped when it errors, times out, doesn't know the ip address or its answer is low confidence.
+// If every link is skipped, the most accurate low confidence answer is returned, or else the last error.
+type Chain struct {
+	links []ChainLink
+}
+
+// NewChain creates a provider trying links in order
+func NewChain(links ...ChainLink) *Chain {
+	return &Chain{
+		links: links,
+	}
+}
+
+// Lookup looks up the ip address with each link in turn.
+func (c *Chain) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	var best *Location
+	err := fmt.Errorf("empty provider chain: %w", ErrNotFound)
+
+	for i := range c.links {
+		link := &c.links[i]
+
+		location, linkErr := link.lookup(ctx, ipAddress)
+		if linkErr != nil {
+			// The caller gave up, don't try the others
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			err = linkErr
+			continue
+		}
+
+		if !link.isLowConfidence(location) {
+			return location, nil
+		}
+		if best == nil || location.AccuracyRadius < best.AccuracyRadius {
+			best = location
+		}
+	}
+
+	if best != nil {
+		return best, nil
+	}
+	return nil, err
+}
+
+// lookup looks up the ip address with the link's timeout and names the answer
+func (l *ChainLink) lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	if l.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.Timeout)
+		defer cancel()
+	}
+
+	location, err := l.Provider.Lookup(ctx, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	if l.Name != "" {
+		// The provider may return a location it keeps, such as a fixed one
+		named := *location
+		named.Provider = l.Name
+		return &named, nil
+	}
+	return location, nil
+}
+
+func (l *ChainLink) isLowConfidence(location *Location) bool {
+	return l.MaxAccuracyRadius > 0 && location.AccuracyRadius > l.MaxAccuracyRadius
+}
@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func staticProvider(location *Location, err error) Func {
+	return func(ctx context.Context, ipAddress string) (*Location, error) {
+		if err != nil {
+			return nil, err
+		}
+		answer := *location
+		return &answer, nil
+	}
+}
+
+func TestChain(t *testing.T) {
+	errDown := errors.New("connection refused")
+	slow := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	tests := []struct {
+		err              error
+		expectedProvider string
+		links            []ChainLink
+		expectedRadius   float64
+	}{
+		// First answer wins
+		{
+			links: []ChainLink{
+				{Provider: staticProvider(&Location{Provider: "mmdb"}, nil)},
+				{Provider: staticProvider(&Location{Provider: "ipbase"}, nil)},
+			},
+			expectedProvider: "mmdb",
+		},
+		// Skips misses and errors
+		{
+			links: []ChainLink{
+				{Provider: staticProvider(nil, ErrNotFound), Name: "mmdb"},
+				{Provider: staticProvider(nil, errDown), Name: "ipbase"},
+				{Provider: staticProvider(&Location{}, nil), Name: "ipinfo"},
+			},
+			expectedProvider: "ipinfo",
+		},
+		// Skips timeouts
+		{
+			links: []ChainLink{
+				{Provider: slow, Name: "slow", Timeout: time.Millisecond},
+				{Provider: staticProvider(&Location{}, nil), Name: "fast"},
+			},
+			expectedProvider: "fast",
+		},
+		// Skips low confidence answers
+		{
+			links: []ChainLink{
+				{Provider: staticProvider(&Location{AccuracyRadius: 500}, nil), Name: "mmdb", MaxAccuracyRadius: 100},
+				{Provider: staticProvider(&Location{AccuracyRadius: 20}, nil), Name: "ipbase", MaxAccuracyRadius: 100},
+			},
+			expectedProvider: "ipbase",
+			expectedRadius:   20,
+		},
+		// Falls back to the most accurate low confidence answer
+		{
+			links: []ChainLink{
+				{Provider: staticProvider(&Location{AccuracyRadius: 500}, nil), Name: "mmdb", MaxAccuracyRadius: 100},
+				{Provider: staticProvider(&Location{AccuracyRadius: 200}, nil), Name: "ipbase", MaxAccuracyRadius: 100},
+				{Provider: staticProvider(nil, errDown), Name: "ipinfo"},
+			},
+			expectedProvider: "ipbase",
+			expectedRadius:   200,
+		},
+		// Returns the last error
+		{
+			links: []ChainLink{
+				{Provider: staticProvider(nil, ErrNotFound)},
+				{Provider: staticProvider(nil, errDown)},
+			},
+			err: errDown,
+		},
+		{
+			err: ErrNotFound,
+		},
+	}
+	for _, test := range tests {
+		location, err := NewChain(test.links...).Lookup(context.TODO(), "8.8.8.8")
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedProvider, location.Provider)
+		assert.Equal(t, test.expectedRadius, location.AccuracyRadius)
+	}
+}
+
+func TestChainContextCanceled(t *testing.T) {
+	calls := 0
+	p := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		calls++
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewChain(ChainLink{Provider: p}, ChainLink{Provider: p}).Lookup(ctx, "8.8.8.8")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
+
+func TestChainKeepsProviderLocation(t *testing.T) {
+	// The provider's location is shared between lookups and must not be modified
+	shared := &Location{Provider: "fixed", Latitude: 1, Longitude: 2}
+	p := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		return shared, nil
+	})
+
+	location, err := NewChain(ChainLink{Provider: p, Name: "named"}).Lookup(context.TODO(), "8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "named", location.Provider)
+	assert.Equal(t, "fixed", shared.Provider)
+}
@@ -0,0 +1,236 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EpicStep/go-simple-geo/v2/geo"
+)
+
+const (
+	consensusProviderName = "consensus"
+)
+
+// ErrDisagreement matches a *DisagreementError with errors.Is
+var ErrDisagreement = errors.New("providers disagree on the location")
+
+// DisagreementError is returned by Consensus when its sources disagree by more than allowed
+type DisagreementError struct {
+	Agreement *Agreement
+	Reason    string
+}
+
+func (e *DisagreementError) Error() string {
+	return ErrDisagreement.Error() + ": " + e.Reason
+}
+
+// Is makes errors.Is(err, ErrDisagreement) true
+func (e *DisagreementError) Is(target error) bool {
+	return target == ErrDisagreement
+}
+
+// ConsensusOptions configures a Consensus
+type ConsensusOptions struct {
+	// Timeout bounds the lookup of each source. Disabled if <= 0.
+	Timeout time.Duration
+	// MinAnswers is how many sources must answer. Defaults to 1.
+	MinAnswers int
+	// MaxSpread in kilometers. Lookups fail with a DisagreementError if two answers are further apart. Disabled if <= 0.
+	MaxSpread float64
+	// RequireCountryMajority fails lookups with a DisagreementError unless more than half of the answers
+	// that know the country agree on it
+	RequireCountryMajority bool
+}
+
+// Agreement describes how the sources of a Consensus agreed
+type Agreement struct {
+	// Answers are the locations returned by the sources that answered, in the order of the sources
+	Answers []*Location
+	// Errors are the errors of the sources that didn't answer
+	Errors []error
+	// Spread is the largest distance in kilometers between two answers
+	Spread float64
+	// CountryVotes is how many answers are in the majority country
+	CountryVotes int
+	// Sources is how many sources were asked
+	Sources int
+}
+
+// Consensus is a Provider that asks several sources in parallel and combines their answers.
+// The combined location has the median coordinates, the majority country and the largest accuracy radius
+// of the answers. Its Agreement records how far apart the answers were.
+type Consensus struct {
+	providers []Provider
+	opts      ConsensusOptions
+}
+
+// NewConsensus creates a provider combining the answers of providers
+func NewConsensus(opts *ConsensusOptions, providers ...Provider) *Consensus {
+	c := &Consensus{
+		providers: providers,
+		opts:      *opts,
+	}
+	if c.opts.MinAnswers <= 0 {
+		c.opts.MinAnswers = 1
+	}
+	return c
+}
+
+// Lookup looks up the ip address with every source and combines the answers.
+func (c *Consensus) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	locations := make([]*Location, len(c.providers))
+	errs := make([]error, len(c.providers))
+
+	var wg sync.WaitGroup
+	for i, p := range c.providers {
+		wg.Add(1)
+		go func(i int, p Provider) {
+			defer wg.Done()
+			lookupCtx := ctx
+			if c.opts.Timeout > 0 {
+				var cancel context.CancelFunc
+				lookupCtx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+				defer cancel()
+			}
+			locations[i], errs[i] = p.Lookup(lookupCtx, ipAddress)
+		}(i, p)
+	}
+	wg.Wait()
+
+	agreement := &Agreement{Sources: len(c.providers)}
+	var lastErr error
+	for i := range c.providers {
+		if errs[i] != nil {
+			agreement.Errors = append(agreement.Errors, errs[i])
+			lastErr = errs[i]
+			continue
+		}
+		agreement.Answers = append(agreement.Answers, locations[i])
+	}
+
+	if len(agreement.Answers) < c.opts.MinAnswers {
+		if lastErr == nil {
+			lastErr = ErrNotFound
+		}
+		return nil, fmt.Errorf("%d of %d providers answered, %d required: %w",
+			len(agreement.Answers), agreement.Sources, c.opts.MinAnswers, lastErr)
+	}
+
+	location := combine(agreement)
+
+	if c.opts.MaxSpread > 0 && agreement.Spread > c.opts.MaxSpread {
+		return nil, &DisagreementError{
+			Agreement: agreement,
+			Reason:    fmt.Sprintf("answers are %.0fkm apart", agreement.Spread),
+		}
+	}
+	if c.opts.RequireCountryMajority && location.Country != "" && !isMajority(agreement) {
+		return nil, &DisagreementError{
+			Agreement: agreement,
+			Reason:    fmt.Sprintf("only %d of %d answers agree on %s", agreement.CountryVotes, len(agreement.Answers), location.Country),
+		}
+	}
+
+	return location, nil
+}
+
+// combine merges the answers of an agreement into one location and fills in the agreement's spread and votes
+func combine(agreement *Agreement) *Location {
+	answers := agreement.Answers
+	latitudes := make([]float64, len(answers))
+	longitudes := make([]float64, len(answers))
+	location := &Location{
+		Agreement: agreement,
+		Provider:  consensusProviderName,
+	}
+
+	for i, answer := range answers {
+		latitudes[i] = answer.Latitude
+		longitudes[i] = answer.Longitude
+		location.Attempts += answer.Attempts
+		if answer.AccuracyRadius > location.AccuracyRadius {
+			location.AccuracyRadius = answer.AccuracyRadius
+		}
+		for _, other := range answers[i+1:] {
+			distance := geo.NewCoordinatesFromDegrees(answer.Latitude, answer.Longitude).
+				Distance(geo.NewCoordinatesFromDegrees(other.Latitude, other.Longitude))
+			if distance > agreement.Spread {
+				agreement.Spread = distance
+			}
+		}
+	}
+
+	location.Latitude = median(latitudes)
+	location.Longitude = medianLongitude(longitudes)
+	location.Country, agreement.CountryVotes = majorityCountry(answers)
+
+	return location
+}
+
+// median returns the median of values, which it sorts
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// medianLongitude returns the median of longitudes, which it modifies, across the antimeridian.
+// Longitudes are measured from the first one the short way around, so 179.9 and -179.9 are 0.2 degrees apart.
+func medianLongitude(longitudes []float64) float64 {
+	reference := longitudes[0]
+	for i, longitude := range longitudes {
+		longitudes[i] = wrapLongitude(longitude - reference)
+	}
+	return wrapLongitude(reference + median(longitudes))
+}
+
+// wrapLongitude wraps a longitude into (-180, 180]
+func wrapLongitude(longitude float64) float64 {
+	longitude = math.Mod(longitude, 360)
+	switch {
+	case longitude > 180:
+		return longitude - 360
+	case longitude <= -180:
+		return longitude + 360
+	default:
+		return longitude
+	}
+}
+
+// majorityCountry returns the most common known country and its count.
+// Ties go to the country answered by the earliest source.
+func majorityCountry(answers []*Location) (string, int) {
+	votes := map[string]int{}
+	country, count := "", 0
+	for _, answer := range answers {
+		if answer.Country == "" {
+			continue
+		}
+		votes[answer.Country]++
+	}
+	for _, answer := range answers {
+		if votes[answer.Country] > count {
+			country, count = answer.Country, votes[answer.Country]
+		}
+	}
+	return country, count
+}
+
+// isMajority reports whether more than half of the answers knowing the country agree on it
+func isMajority(agreement *Agreement) bool {
+	known := 0
+	for _, answer := range agreement.Answers {
+		if answer.Country != "" {
+			known++
+		}
+	}
+	return agreement.CountryVotes*2 > known
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsensus(t *testing.T) {
+	errDown := errors.New("connection refused")
+	// Kansas, Missouri, Nebraska and Paris
+	kansas := &Location{Latitude: 37.751, Longitude: -97.822, Country: "US", AccuracyRadius: 100, Attempts: 1}
+	missouri := &Location{Latitude: 38.627, Longitude: -90.199, Country: "US", AccuracyRadius: 50, Attempts: 1}
+	nebraska := &Location{Latitude: 41.256, Longitude: -95.934, Country: "US", Attempts: 2}
+	paris := &Location{Latitude: 48.857, Longitude: 2.352, Country: "FR", Attempts: 1}
+
+	tests := []struct {
+		err               error
+		expected          *Location
+		providers         []Provider
+		opts              ConsensusOptions
+		expectedVotes     int
+		expectedMinSpread float64
+		expectedMaxSpread float64
+	}{
+		{
+			providers: []Provider{
+				staticProvider(kansas, nil),
+				staticProvider(missouri, nil),
+				staticProvider(nebraska, nil),
+				staticProvider(nil, errDown),
+			},
+			expected: &Location{
+				Provider:       consensusProviderName,
+				Country:        "US",
+				Latitude:       38.627,
+				Longitude:      -95.934,
+				AccuracyRadius: 100,
+				Attempts:       4,
+			},
+			expectedVotes:     3,
+			expectedMinSpread: 600,
+			expectedMaxSpread: 700,
+		},
+		// Median of an even number of answers
+		{
+			providers: []Provider{
+				staticProvider(kansas, nil),
+				staticProvider(missouri, nil),
+			},
+			expected: &Location{
+				Provider:       consensusProviderName,
+				Country:        "US",
+				Latitude:       (kansas.Latitude + missouri.Latitude) / 2,
+				Longitude:      (kansas.Longitude + missouri.Longitude) / 2,
+				AccuracyRadius: 100,
+				Attempts:       2,
+			},
+			expectedVotes:     2,
+			expectedMinSpread: 600,
+			expectedMaxSpread: 700,
+		},
+		{
+			opts: ConsensusOptions{MaxSpread: 1000},
+			providers: []Provider{
+				staticProvider(kansas, nil),
+				staticProvider(paris, nil),
+			},
+			err: ErrDisagreement,
+		},
+		{
+			opts: ConsensusOptions{RequireCountryMajority: true},
+			providers: []Provider{
+				staticProvider(kansas, nil),
+				staticProvider(paris, nil),
+			},
+			err: ErrDisagreement,
+		},
+		{
+			opts: ConsensusOptions{MinAnswers: 2},
+			providers: []Provider{
+				staticProvider(kansas, nil),
+				staticProvider(nil, errDown),
+			},
+			err: errDown,
+		},
+		{
+			err: ErrNotFound,
+		},
+	}
+	for _, test := range tests {
+		location, err := NewConsensus(&test.opts, test.providers...).Lookup(context.TODO(), "8.8.8.8")
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err)
+			var disagreementErr *DisagreementError
+			if errors.As(err, &disagreementErr) {
+				assert.Len(t, disagreementErr.Agreement.Answers, len(test.providers))
+			}
+			continue
+		}
+		assert.NoError(t, err)
+
+		agreement := location.Agreement
+		assert.Equal(t, len(test.providers), agreement.Sources)
+		assert.Equal(t, test.expectedVotes, agreement.CountryVotes)
+		assert.Greater(t, agreement.Spread, test.expectedMinSpread)
+		assert.Less(t, agreement.Spread, test.expectedMaxSpread)
+
+		location.Agreement = nil
+		assert.Equal(t, test.expected, location)
+	}
+}
+
+func TestConsensusTimeout(t *testing.T) {
+	slow := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	start := time.Now()
+	location, err := NewConsensus(&ConsensusOptions{Timeout: 10 * time.Millisecond},
+		slow, staticProvider(&Location{Country: "US"}, nil)).Lookup(context.TODO(), "8.8.8.8")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "US", location.Country)
+	assert.Len(t, location.Agreement.Errors, 1)
+	assert.ErrorIs(t, location.Agreement.Errors[0], context.DeadlineExceeded)
+}
+
+func TestMedianLongitude(t *testing.T) {
+	tests := []struct {
+		longitudes []float64
+		expected   float64
+	}{
+		{longitudes: []float64{-97.822, -90.199, -95.934}, expected: -95.934},
+		{longitudes: []float64{2.352, -0.128}, expected: 1.112},
+		// Fiji, on both sides of the antimeridian
+		{longitudes: []float64{179.9, -179.9}, expected: 180},
+		{longitudes: []float64{-179.9, 179.9}, expected: 180},
+		{longitudes: []float64{179.5, -179.9, -179.7}, expected: -179.9},
+		{longitudes: []float64{-179.9, 179.5, 179.7}, expected: 179.7},
+	}
+	for _, test := range tests {
+		assert.InDelta(t, test.expected, medianLongitude(test.longitudes), 1e-9, test.longitudes)
+	}
+
+	// Answers across the antimeridian are close, not on opposite sides of the world
+	fiji := staticProvider(&Location{Latitude: -17.8, Longitude: 179.9, Country: "FJ"}, nil)
+	taveuni := staticProvider(&Location{Latitude: -16.9, Longitude: -179.9, Country: "FJ"}, nil)
+	location, err := NewConsensus(&ConsensusOptions{MaxSpread: 200}, fiji, taveuni).Lookup(context.TODO(), "8.8.8.8")
+	assert.NoError(t, err)
+	assert.InDelta(t, 180, math.Abs(location.Longitude), 1e-9)
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	dbipProviderName = "dbip"
+	// dbipCityColumns are ip_start, ip_end, continent, country, stateprov, city, latitude and longitude
+	dbipCityColumns = 8
+)
+
+// LoadDBIPCSV loads a DB-IP city database in csv format, such as dbip-city-lite, containing ipv4 and ipv6 ranges.
+// Ranges without a known country are skipped.
+func LoadDBIPCSV(r io.Reader) (*RangeDatabase, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	builder := newRangeBuilder(dbipProviderName)
+
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < dbipCityColumns {
+			return nil, fmt.Errorf("dbip csv line %d: expected %d columns, got %d", line, dbipCityColumns, len(row))
+		}
+
+		start, err := netip.ParseAddr(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("dbip csv line %d: %w", line, err)
+		}
+		end, err := netip.ParseAddr(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("dbip csv line %d: %w", line, err)
+		}
+		country := row[3]
+		if country == "" || country == "ZZ" {
+			continue
+		}
+		latitude, longitude, err := parseCoordinates(row[6], row[7])
+		if err != nil {
+			return nil, fmt.Errorf("dbip csv line %d: %w", line, err)
+		}
+
+		builder.add(start, end, rangeRecord{
+			country:   country,
+			latitude:  latitude,
+			longitude: longitude,
+		})
+	}
+
+	return builder.build(), nil
+}
+
+// parseCoordinates parses a latitude and longitude given in decimal degrees
+func parseCoordinates(lat, lon string) (float64, float64, error) {
+	latitude, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	longitude, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return latitude, longitude, nil
+}
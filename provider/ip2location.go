@@ -0,0 +1,270 @@
+package provider
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	ip2locationProviderName = "ip2location"
+	// ip2locationCSVColumns are ip_from, ip_to, country_code, country_name, region_name, city_name, latitude and longitude
+	ip2locationCSVColumns = 8
+	ip2locationHeaderSize = 64
+)
+
+var (
+	// ErrUnsupportedDatabase is returned when a database file doesn't contain coordinates
+	ErrUnsupportedDatabase = errors.New("unsupported database type")
+
+	// Column positions by IP2Location database type, 0 if the type doesn't have the column.
+	// Position 1 is ip_from.
+	ip2locationCountryPosition   = [...]uint32{0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+	ip2locationLatitudePosition  = [...]uint32{0, 0, 0, 0, 0, 5, 5, 0, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5}
+	ip2locationLongitudePosition = [...]uint32{0, 0, 0, 0, 0, 6, 6, 0, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6}
+)
+
+// LoadIP2LocationCSV loads an IP2Location DB5 or higher database in csv format.
+// Both the ipv4 and ipv6 editions are supported, ipv4-mapped ranges of the ipv6 edition are stored as ipv4.
+// Ranges without a known country are skipped.
+func LoadIP2LocationCSV(r io.Reader) (*RangeDatabase, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	builder := newRangeBuilder(ip2locationProviderName)
+
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < ip2locationCSVColumns {
+			return nil, fmt.Errorf("ip2location csv line %d: expected %d columns, got %d", line, ip2locationCSVColumns, len(row))
+		}
+
+		start, end, err := parseIPNumberRange(row[0], row[1])
+		if err != nil {
+			return nil, fmt.Errorf("ip2location csv line %d: %w", line, err)
+		}
+		country := row[2]
+		if country == "" || country == "-" {
+			continue
+		}
+		latitude, longitude, err := parseCoordinates(row[6], row[7])
+		if err != nil {
+			return nil, fmt.Errorf("ip2location csv line %d: %w", line, err)
+		}
+
+		builder.add(start, end, rangeRecord{
+			country:   country,
+			latitude:  latitude,
+			longitude: longitude,
+		})
+	}
+
+	return builder.build(), nil
+}
+
+// parseIPNumberRange parses a range of addresses given as decimal numbers.
+// Ranges ending below 2^32 are ipv4, others are ipv6.
+func parseIPNumberRange(from, to string) (netip.Addr, netip.Addr, error) {
+	start, err := parseIPNumber(from)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	end, err := parseIPNumber(to)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+
+	if end.hi == 0 && end.lo <= math.MaxUint32 {
+		return uint32ToAddr(uint32(start.lo)), uint32ToAddr(uint32(end.lo)), nil
+	}
+	return uint128ToAddr(start), uint128ToAddr(end), nil
+}
+
+// parseIPNumber parses an address given as a decimal number of up to 128 bits
+func parseIPNumber(s string) (uint128, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return uint128{lo: n}, nil
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return uint128{}, fmt.Errorf("invalid ip number %q", s)
+	}
+	var b [16]byte
+	n.FillBytes(b[:])
+	return uint128{
+		hi: binary.BigEndian.Uint64(b[:8]),
+		lo: binary.BigEndian.Uint64(b[8:]),
+	}, nil
+}
+
+func uint32ToAddr(n uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	return netip.AddrFrom4(b)
+}
+
+func uint128ToAddr(n uint128) netip.Addr {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], n.hi)
+	binary.BigEndian.PutUint64(b[8:], n.lo)
+	return netip.AddrFrom16(b)
+}
+
+// ip2locationBIN reads the IP2Location BIN format.
+// Offsets in the header and rows are 1-based, except string pointers.
+type ip2locationBIN struct {
+	data             []byte
+	countryOffset    uint32
+	latitudeOffset   uint32
+	longitudeOffset  uint32
+	columns          uint32
+	ipv4Count        uint32
+	ipv4Addr         uint32
+	ipv6Count        uint32
+	ipv6Addr         uint32
+	hasCountryColumn bool
+}
+
+// LoadIP2LocationBIN loads an IP2Location DB5 or higher database in BIN format.
+// The whole file is read into memory and indexed, it isn't needed afterwards.
+func LoadIP2LocationBIN(r io.Reader) (*RangeDatabase, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < ip2locationHeaderSize {
+		return nil, fmt.Errorf("ip2location bin: %w: file too short", ErrUnsupportedDatabase)
+	}
+
+	dbType := uint32(data[0])
+	if dbType == 0 || dbType >= uint32(len(ip2locationLatitudePosition)) || ip2locationLatitudePosition[dbType] == 0 {
+		return nil, fmt.Errorf("ip2location bin: %w: DB%d has no coordinates", ErrUnsupportedDatabase, dbType)
+	}
+
+	// Rows must be wide enough for the columns of the type, the longitude comes last
+	columns := uint32(data[1])
+	if columns < ip2locationLongitudePosition[dbType] {
+		return nil, fmt.Errorf("ip2location bin: %w: DB%d needs %d columns, header has %d",
+			ErrUnsupportedDatabase, dbType, ip2locationLongitudePosition[dbType], columns)
+	}
+
+	bin := &ip2locationBIN{
+		data:             data,
+		columns:          columns,
+		ipv4Count:        binary.LittleEndian.Uint32(data[5:]),
+		ipv4Addr:         binary.LittleEndian.Uint32(data[9:]),
+		ipv6Count:        binary.LittleEndian.Uint32(data[13:]),
+		ipv6Addr:         binary.LittleEndian.Uint32(data[17:]),
+		hasCountryColumn: ip2locationCountryPosition[dbType] != 0,
+		// Offsets within a row after its ip_from column
+		countryOffset:   (ip2locationCountryPosition[dbType] - 2) * 4,
+		latitudeOffset:  (ip2locationLatitudePosition[dbType] - 2) * 4,
+		longitudeOffset: (ip2locationLongitudePosition[dbType] - 2) * 4,
+	}
+
+	builder := newRangeBuilder(ip2locationProviderName)
+	if err := bin.load(builder, 4); err != nil {
+		return nil, fmt.Errorf("ip2location bin: %w", err)
+	}
+	if err := bin.load(builder, 16); err != nil {
+		return nil, fmt.Errorf("ip2location bin: %w", err)
+	}
+	return builder.build(), nil
+}
+
+// load adds the ipv4 or ipv6 rows, depending on the size of ip_from.
+// Each row ends where the next begins, the table has an extra row marking the end of the last.
+func (b *ip2locationBIN) load(builder *rangeBuilder, ipSize uint32) error {
+	count, base := b.ipv4Count, b.ipv4Addr
+	if ipSize == 16 {
+		count, base = b.ipv6Count, b.ipv6Addr
+	}
+	if count == 0 {
+		return nil
+	}
+
+	// Computed in 64 bits so corrupt counts and addresses can't wrap around the bounds check
+	rowSize := uint64(ipSize) + uint64(b.columns-1)*4
+	if base == 0 || uint64(base-1)+(uint64(count)+1)*rowSize > uint64(len(b.data)) {
+		return errors.New("truncated file")
+	}
+
+	for i := uint64(0); i < uint64(count); i++ {
+		row := b.data[uint64(base-1)+i*rowSize:]
+		next := row[rowSize:]
+		columns := row[ipSize:rowSize]
+
+		record := rangeRecord{
+			latitude:  roundCoordinate(math.Float32frombits(binary.LittleEndian.Uint32(columns[b.latitudeOffset:]))),
+			longitude: roundCoordinate(math.Float32frombits(binary.LittleEndian.Uint32(columns[b.longitudeOffset:]))),
+		}
+		if b.hasCountryColumn {
+			country, err := b.readString(binary.LittleEndian.Uint32(columns[b.countryOffset:]))
+			if err != nil {
+				return err
+			}
+			if country == "-" {
+				continue
+			}
+			record.country = country
+		}
+
+		var start, end netip.Addr
+		if ipSize == 4 {
+			start = uint32ToAddr(binary.LittleEndian.Uint32(row))
+			end = uint32ToAddr(binary.LittleEndian.Uint32(next) - 1)
+		} else {
+			start = uint128ToAddr(readUint128LE(row))
+			end = uint128ToAddr(decrement(readUint128LE(next)))
+		}
+		builder.add(start, end, record)
+	}
+	return nil
+}
+
+// readString reads a string prefixed by its length at a 0-based pointer
+func (b *ip2locationBIN) readString(pointer uint32) (string, error) {
+	if uint64(pointer) >= uint64(len(b.data)) {
+		return "", errors.New("string pointer out of range")
+	}
+	length := uint32(b.data[pointer])
+	if uint64(pointer)+1+uint64(length) > uint64(len(b.data)) {
+		return "", errors.New("string out of range")
+	}
+	return string(b.data[pointer+1 : pointer+1+length]), nil
+}
+
+func readUint128LE(b []byte) uint128 {
+	return uint128{
+		hi: binary.LittleEndian.Uint64(b[8:]),
+		lo: binary.LittleEndian.Uint64(b[:8]),
+	}
+}
+
+func decrement(n uint128) uint128 {
+	if n.lo == 0 {
+		n.hi--
+	}
+	n.lo--
+	return n
+}
+
+// roundCoordinate converts a float32 coordinate to the float64 with the same shortest decimal representation,
+// so 153.017 stays 153.017 rather than becoming 153.01699829
+func roundCoordinate(f float32) float64 {
+	coordinate, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return coordinate
+}
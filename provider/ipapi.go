@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	ipapiBaseURL      = "http://ip-api.com"
+	ipapiProBaseURL   = "https://pro.ip-api.com"
+	ipapiProviderName = "ip-api"
+	ipapiFields       = "status,message,countryCode,lat,lon"
+)
+
+// ipapiResponse is the json response from ip-api.com
+type ipapiResponse struct {
+	Status      string  `json:"status"`
+	Message     string  `json:"message"`
+	CountryCode string  `json:"countryCode"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+}
+
+// IPAPIProvider looks up ip addresses with https://ip-api.com
+type IPAPIProvider struct {
+	client *resty.Client
+	key    string
+}
+
+// NewIPAPIProvider creates a provider for https://ip-api.com.
+// Without a key the free endpoint is used, which only supports http and 45 requests per minute.
+// With a key the pro endpoint is used over https.
+func NewIPAPIProvider(key string) *IPAPIProvider {
+	return NewIPAPIProviderWithOptions(key, nil)
+}
+
+// NewIPAPIProviderWithOptions works like NewIPAPIProvider but configures its requests with opts.
+// Nil opts use the defaults.
+func NewIPAPIProviderWithOptions(key string, opts *HTTPOptions) *IPAPIProvider {
+	baseURL := ipapiBaseURL
+	if key != "" {
+		baseURL = ipapiProBaseURL
+	}
+	return &IPAPIProvider{
+		client: newAPIClient(baseURL, opts),
+		key:    key,
+	}
+}
+
+// Lookup fetches the location of the ip address from ip-api.com.
+// Private and reserved addresses return ErrNotFound.
+func (p *IPAPIProvider) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	response := &ipapiResponse{}
+
+	req := p.client.R().
+		SetContext(ctx).
+		SetQueryParam("fields", ipapiFields).
+		SetPathParam("ip", ipAddress).
+		SetResult(response).
+		SetError(response)
+	if p.key != "" {
+		req.SetQueryParam("key", p.key)
+	}
+
+	resp, err := req.Get("/json/{ip}")
+	if err != nil {
+		return nil, err
+	}
+
+	// ip-api.com answers failed lookups with 200 and a fail status
+	if resp.IsError() || response.Status != "success" {
+		if strings.HasSuffix(response.Message, "range") {
+			return nil, ErrNotFound
+		}
+		return nil, apiError(ipapiProviderName, response.Message, resp)
+	}
+
+	return &Location{
+		Provider:  ipapiProviderName,
+		Country:   response.CountryCode,
+		Latitude:  response.Lat,
+		Longitude: response.Lon,
+		Attempts:  1,
+	}, nil
+}
@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPAPIProvider(t *testing.T) {
+	fields := url.QueryEscape(ipapiFields)
+	tests := []struct {
+		response   interface{}
+		expected   *Location
+		err        error
+		key        string
+		ipAddress  string
+		endpoint   string
+		statusCode int
+	}{
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/json/8.8.8.8?fields=%s", ipapiBaseURL, fields),
+			statusCode: 200,
+			response:   &ipapiResponse{Status: "success", CountryCode: "US", Lat: 37.751, Lon: -97.822},
+			expected:   &Location{Provider: "ip-api", Country: "US", Latitude: 37.751, Longitude: -97.822, Attempts: 1},
+		},
+		// Pro endpoint
+		{
+			key:        "fakeKey",
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/json/8.8.8.8?fields=%s&key=fakeKey", ipapiProBaseURL, fields),
+			statusCode: 200,
+			response:   &ipapiResponse{Status: "success", CountryCode: "US", Lat: 37.751, Lon: -97.822},
+			expected:   &Location{Provider: "ip-api", Country: "US", Latitude: 37.751, Longitude: -97.822, Attempts: 1},
+		},
+		// Caller's own ip address
+		{
+			endpoint:   fmt.Sprintf("%s/json/?fields=%s", ipapiBaseURL, fields),
+			statusCode: 200,
+			response:   &ipapiResponse{Status: "success", CountryCode: "DE", Lat: 52.52, Lon: 13.405},
+			expected:   &Location{Provider: "ip-api", Country: "DE", Latitude: 52.52, Longitude: 13.405, Attempts: 1},
+		},
+		{
+			ipAddress:  "192.168.1.1",
+			endpoint:   fmt.Sprintf("%s/json/192.168.1.1?fields=%s", ipapiBaseURL, fields),
+			statusCode: 200,
+			response:   &ipapiResponse{Status: "fail", Message: "private range"},
+			err:        ErrNotFound,
+		},
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/json/8.8.8.8?fields=%s", ipapiBaseURL, fields),
+			statusCode: 200,
+			response:   &ipapiResponse{Status: "fail", Message: "invalid query"},
+			err:        &APIError{Provider: "ip-api", Message: "invalid query"},
+		},
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/json/8.8.8.8?fields=%s", ipapiBaseURL, fields),
+			statusCode: 429,
+			response:   "",
+			err:        &APIError{Provider: "ip-api", Message: "Too Many Requests"},
+		},
+	}
+	for _, test := range tests {
+		transport := httpmock.NewMockTransport()
+		p := NewIPAPIProviderWithOptions(test.key, &HTTPOptions{Transport: transport})
+		transport.RegisterResponder("GET", test.endpoint, httpmock.NewJsonResponderOrPanic(test.statusCode, test.response))
+
+		location, err := p.Lookup(context.TODO(), test.ipAddress)
+		assertLookup(t, test.expected, test.err, location, err)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	}
+}
+
+// assertLookup checks the result of a lookup against the expected location or error.
+// An expected *APIError is compared by value.
+func assertLookup(t *testing.T, expected *Location, expectedErr error, actual *Location, err error) {
+	t.Helper()
+	if expectedErr == nil {
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		return
+	}
+
+	assert.Nil(t, actual)
+	if expectedAPIErr, ok := expectedErr.(*APIError); ok {
+		var apiErr *APIError
+		assert.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, expectedAPIErr, apiErr)
+		return
+	}
+	assert.ErrorIs(t, err, expectedErr)
+}
+
+func TestAPIProviderHTTPOptions(t *testing.T) {
+	tests := []struct {
+		response interface{}
+		build    func(opts *HTTPOptions) Provider
+		endpoint string
+	}{
+		{
+			build:    func(opts *HTTPOptions) Provider { return NewIPAPIProviderWithOptions("", opts) },
+			endpoint: "https://ip-api.example.com/json/8.8.8.8?fields=" + url.QueryEscape(ipapiFields),
+			response: &ipapiResponse{Status: "success", CountryCode: "US", Lat: 37.751, Lon: -97.822},
+		},
+		{
+			build:    func(opts *HTTPOptions) Provider { return NewIPInfoProviderWithOptions("fakeToken", opts) },
+			endpoint: "https://ip-api.example.com/8.8.8.8/json",
+			response: &ipinfoResponse{Country: "US", Loc: "37.751,-97.822"},
+		},
+		{
+			build:    func(opts *HTTPOptions) Provider { return NewIPGeolocationProviderWithOptions("fakeApiKey", opts) },
+			endpoint: "https://ip-api.example.com/ipgeo?apiKey=fakeApiKey&fields=geo&ip=8.8.8.8",
+			response: &ipgeolocationResponse{CountryCode2: "US", Latitude: "37.751", Longitude: "-97.822"},
+		},
+	}
+	for _, test := range tests {
+		httpClient := &http.Client{}
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", test.endpoint, httpmock.NewJsonResponderOrPanic(200, test.response))
+
+		p := test.build(&HTTPOptions{
+			HTTPClient: httpClient,
+			Transport:  transport,
+			BaseURL:    "https://ip-api.example.com/",
+			Timeout:    time.Second,
+		})
+		location, err := p.Lookup(context.TODO(), "8.8.8.8")
+		assert.NoError(t, err, test.endpoint)
+		assert.Equal(t, "US", location.Country)
+		assert.Equal(t, 1, transport.GetTotalCallCount(), test.endpoint)
+
+		// The client passed in isn't modified
+		assert.Nil(t, httpClient.Transport)
+		assert.Zero(t, httpClient.Timeout)
+
+		// Requests slower than the timeout fail
+		blocked := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-blocked:
+			}
+		}))
+		p = test.build(&HTTPOptions{BaseURL: slow.URL, Timeout: 10 * time.Millisecond})
+		_, err = p.Lookup(context.TODO(), "8.8.8.8")
+		var urlError *url.Error
+		assert.ErrorAs(t, err, &urlError)
+		assert.True(t, urlError.Timeout())
+		close(blocked)
+		slow.Close()
+	}
+}
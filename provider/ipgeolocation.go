@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	ipgeolocationBaseURL      = "https://api.ipgeolocation.io"
+	ipgeolocationProviderName = "ipgeolocation"
+)
+
+// ipgeolocationResponse is the json response from ipgeolocation.io.
+// Coordinates are returned as strings.
+type ipgeolocationResponse struct {
+	CountryCode2 string `json:"country_code2"`
+	Latitude     string `json:"latitude"`
+	Longitude    string `json:"longitude"`
+}
+
+// ipgeolocationError is the json response when there is an error from ipgeolocation.io
+type ipgeolocationError struct {
+	Message string `json:"message"`
+}
+
+// IPGeolocationProvider looks up ip addresses with https://ipgeolocation.io
+type IPGeolocationProvider struct {
+	client *resty.Client
+	apiKey string
+}
+
+// NewIPGeolocationProvider creates a provider for https://ipgeolocation.io using the api key
+func NewIPGeolocationProvider(apiKey string) *IPGeolocationProvider {
+	return NewIPGeolocationProviderWithOptions(apiKey, nil)
+}
+
+// NewIPGeolocationProviderWithOptions works like NewIPGeolocationProvider but configures its requests with opts.
+// Nil opts use the defaults.
+func NewIPGeolocationProviderWithOptions(apiKey string, opts *HTTPOptions) *IPGeolocationProvider {
+	return &IPGeolocationProvider{
+		client: newAPIClient(ipgeolocationBaseURL, opts),
+		apiKey: apiKey,
+	}
+}
+
+// Lookup fetches the location of the ip address from ipgeolocation.io.
+// Bogon addresses return ErrNotFound.
+func (p *IPGeolocationProvider) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	response := &ipgeolocationResponse{}
+	ipgeolocationErr := &ipgeolocationError{}
+
+	req := p.client.R().
+		SetContext(ctx).
+		SetQueryParam("apiKey", p.apiKey).
+		SetQueryParam("fields", "geo").
+		SetResult(response).
+		SetError(ipgeolocationErr)
+	if ipAddress != "" {
+		req.SetQueryParam("ip", ipAddress)
+	}
+
+	resp, err := req.Get("/ipgeo")
+	if err != nil {
+		return nil, err
+	}
+
+	// Private and reserved addresses are answered with 423 Locked
+	if resp.StatusCode() == http.StatusLocked {
+		return nil, ErrNotFound
+	}
+	if resp.IsError() {
+		return nil, apiError(ipgeolocationProviderName, ipgeolocationErr.Message, resp)
+	}
+
+	latitude, err := strconv.ParseFloat(response.Latitude, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid latitude %q: %w", ipgeolocationProviderName, response.Latitude, err)
+	}
+	longitude, err := strconv.ParseFloat(response.Longitude, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid longitude %q: %w", ipgeolocationProviderName, response.Longitude, err)
+	}
+
+	return &Location{
+		Provider:  ipgeolocationProviderName,
+		Country:   response.CountryCode2,
+		Latitude:  latitude,
+		Longitude: longitude,
+		Attempts:  1,
+	}, nil
+}
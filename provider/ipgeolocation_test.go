@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPGeolocationProvider(t *testing.T) {
+	fakeAPIKey := "fakeApiKey"
+	tests := []struct {
+		response   interface{}
+		expected   *Location
+		err        error
+		ipAddress  string
+		endpoint   string
+		statusCode int
+	}{
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/ipgeo?apiKey=%s&fields=geo&ip=8.8.8.8", ipgeolocationBaseURL, fakeAPIKey),
+			statusCode: 200,
+			response:   &ipgeolocationResponse{CountryCode2: "US", Latitude: "37.42240", Longitude: "-122.08421"},
+			expected:   &Location{Provider: "ipgeolocation", Country: "US", Latitude: 37.4224, Longitude: -122.08421, Attempts: 1},
+		},
+		// Caller's own ip address
+		{
+			endpoint:   fmt.Sprintf("%s/ipgeo?apiKey=%s&fields=geo", ipgeolocationBaseURL, fakeAPIKey),
+			statusCode: 200,
+			response:   &ipgeolocationResponse{CountryCode2: "DE", Latitude: "52.52000", Longitude: "13.40500"},
+			expected:   &Location{Provider: "ipgeolocation", Country: "DE", Latitude: 52.52, Longitude: 13.405, Attempts: 1},
+		},
+		{
+			ipAddress:  "192.168.1.1",
+			endpoint:   fmt.Sprintf("%s/ipgeo?apiKey=%s&fields=geo&ip=192.168.1.1", ipgeolocationBaseURL, fakeAPIKey),
+			statusCode: 423,
+			response:   &ipgeolocationError{Message: "'192.168.1.1' is a bogon IP address."},
+			err:        ErrNotFound,
+		},
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/ipgeo?apiKey=%s&fields=geo&ip=8.8.8.8", ipgeolocationBaseURL, fakeAPIKey),
+			statusCode: 401,
+			response:   &ipgeolocationError{Message: "Provided API key is not valid."},
+			err:        &APIError{Provider: "ipgeolocation", Message: "Provided API key is not valid."},
+		},
+	}
+	for _, test := range tests {
+		transport := httpmock.NewMockTransport()
+		p := NewIPGeolocationProviderWithOptions(fakeAPIKey, &HTTPOptions{Transport: transport})
+		transport.RegisterResponder("GET", test.endpoint, httpmock.NewJsonResponderOrPanic(test.statusCode, test.response))
+
+		location, err := p.Lookup(context.TODO(), test.ipAddress)
+		assertLookup(t, test.expected, test.err, location, err)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	}
+}
+
+func TestIPGeolocationProviderStatusError(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	p := NewIPGeolocationProviderWithOptions("fakeApiKey", &HTTPOptions{Transport: transport})
+
+	transport.RegisterResponder("GET", fmt.Sprintf("%s/ipgeo?apiKey=fakeApiKey&fields=geo&ip=8.8.8.8", ipgeolocationBaseURL),
+		func(req *http.Request) (*http.Response, error) {
+			resp, err := httpmock.NewJsonResponse(429, &ipgeolocationError{Message: "rate limited"})
+			resp.Header.Set("Retry-After", "30")
+			return resp, err
+		})
+
+	_, err := p.Lookup(context.TODO(), "8.8.8.8")
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, 429, statusErr.StatusCode)
+	assert.Equal(t, 30*time.Second, statusErr.RetryAfter)
+}
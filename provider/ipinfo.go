@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	ipinfoBaseURL      = "https://ipinfo.io"
+	ipinfoProviderName = "ipinfo"
+)
+
+// ipinfoResponse is the json response from ipinfo.io
+type ipinfoResponse struct {
+	Country string `json:"country"`
+	// Loc is the latitude and longitude separated by a comma
+	Loc   string `json:"loc"`
+	Bogon bool   `json:"bogon"`
+}
+
+// ipinfoError is the json response when there is an error from ipinfo.io
+type ipinfoError struct {
+	Error struct {
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// IPInfoProvider looks up ip addresses with https://ipinfo.io
+type IPInfoProvider struct {
+	client *resty.Client
+}
+
+// NewIPInfoProvider creates a provider for https://ipinfo.io using the access token
+func NewIPInfoProvider(token string) *IPInfoProvider {
+	return NewIPInfoProviderWithOptions(token, nil)
+}
+
+// NewIPInfoProviderWithOptions works like NewIPInfoProvider but configures its requests with opts.
+// Nil opts use the defaults.
+func NewIPInfoProviderWithOptions(token string, opts *HTTPOptions) *IPInfoProvider {
+	return &IPInfoProvider{
+		client: newAPIClient(ipinfoBaseURL, opts).SetAuthToken(token),
+	}
+}
+
+// Lookup fetches the location of the ip address from ipinfo.io.
+// Bogon addresses return ErrNotFound.
+func (p *IPInfoProvider) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	response := &ipinfoResponse{}
+	ipinfoErr := &ipinfoError{}
+
+	// The caller's own ip address is looked up without one in the path
+	path := "/json"
+	if ipAddress != "" {
+		path = "/" + ipAddress + "/json"
+	}
+
+	resp, err := p.client.R().
+		SetContext(ctx).
+		SetResult(response).
+		SetError(ipinfoErr).
+		Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IsError() {
+		return nil, apiError(ipinfoProviderName, ipinfoErr.Error.Message, resp)
+	}
+	if response.Bogon || response.Loc == "" {
+		return nil, ErrNotFound
+	}
+
+	latitude, longitude, err := parseLoc(response.Loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Location{
+		Provider:  ipinfoProviderName,
+		Country:   response.Country,
+		Latitude:  latitude,
+		Longitude: longitude,
+		Attempts:  1,
+	}, nil
+}
+
+// parseLoc parses the "latitude,longitude" format of ipinfo.io
+func parseLoc(loc string) (float64, float64, error) {
+	lat, lon, found := strings.Cut(loc, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("%s: invalid loc %q", ipinfoProviderName, loc)
+	}
+	latitude, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: invalid loc %q: %w", ipinfoProviderName, loc, err)
+	}
+	longitude, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: invalid loc %q: %w", ipinfoProviderName, loc, err)
+	}
+	return latitude, longitude, nil
+}
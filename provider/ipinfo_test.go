@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPInfoProvider(t *testing.T) {
+	fakeToken := "fakeToken"
+	tests := []struct {
+		response   interface{}
+		expected   *Location
+		err        error
+		ipAddress  string
+		endpoint   string
+		statusCode int
+	}{
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/8.8.8.8/json", ipinfoBaseURL),
+			statusCode: 200,
+			response:   &ipinfoResponse{Country: "US", Loc: "37.4056,-122.0775"},
+			expected:   &Location{Provider: "ipinfo", Country: "US", Latitude: 37.4056, Longitude: -122.0775, Attempts: 1},
+		},
+		// Caller's own ip address
+		{
+			endpoint:   fmt.Sprintf("%s/json", ipinfoBaseURL),
+			statusCode: 200,
+			response:   &ipinfoResponse{Country: "DE", Loc: "52.5200,13.4050"},
+			expected:   &Location{Provider: "ipinfo", Country: "DE", Latitude: 52.52, Longitude: 13.405, Attempts: 1},
+		},
+		{
+			ipAddress:  "192.168.1.1",
+			endpoint:   fmt.Sprintf("%s/192.168.1.1/json", ipinfoBaseURL),
+			statusCode: 200,
+			response:   &ipinfoResponse{Bogon: true},
+			err:        ErrNotFound,
+		},
+		{
+			ipAddress:  "8.8.8.8",
+			endpoint:   fmt.Sprintf("%s/8.8.8.8/json", ipinfoBaseURL),
+			statusCode: 403,
+			response:   map[string]interface{}{"error": map[string]string{"title": "Unknown token", "message": "Please provide a valid token"}},
+			err:        &APIError{Provider: "ipinfo", Message: "Please provide a valid token"},
+		},
+	}
+	for _, test := range tests {
+		transport := httpmock.NewMockTransport()
+		p := NewIPInfoProviderWithOptions(fakeToken, &HTTPOptions{Transport: transport})
+		transport.RegisterResponder("GET", test.endpoint,
+			func(req *http.Request) (*http.Response, error) {
+				assert.Equal(t, "Bearer "+fakeToken, req.Header.Get("Authorization"))
+				return httpmock.NewJsonResponse(test.statusCode, test.response)
+			})
+
+		location, err := p.Lookup(context.TODO(), test.ipAddress)
+		assertLookup(t, test.expected, test.err, location, err)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	}
+}
+
+func TestParseLoc(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedLatitude  float64
+		expectedLongitude float64
+		err               bool
+	}{
+		{
+			input:             "37.4056,-122.0775",
+			expectedLatitude:  37.4056,
+			expectedLongitude: -122.0775,
+		},
+		{
+			input: "37.4056",
+			err:   true,
+		},
+		{
+			input: "north,-122.0775",
+			err:   true,
+		},
+		{
+			input: "37.4056,west",
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		latitude, longitude, err := parseLoc(test.input)
+		if test.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedLatitude, latitude)
+		assert.Equal(t, test.expectedLongitude, longitude)
+	}
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Provider is an interface for looking up the geolocation of ip addresses.
+// An empty ip address looks up the public ip address of the caller, if supported.
+type Provider interface {
+	Lookup(context.Context, string) (*Location, error)
+}
+
+// Func adapts a function to the Provider interface
+type Func func(context.Context, string) (*Location, error)
+
+// Lookup calls f
+func (f Func) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	return f(ctx, ipAddress)
+}
+
+// ErrNotFound is returned by providers that have no location for an ip address
+var ErrNotFound = errors.New("ip address not found")
+
+// Location is the geolocation of an ip address
+type Location struct {
+	// Agreement describes how the sources of a Consensus agreed, nil for other providers
+	Agreement *Agreement
+	// Provider names the provider that answered
+	Provider string
+	// Country is the ISO 3166-1 alpha-2 country code, empty if unknown
+	Country   string
+	Latitude  float64
+	Longitude float64
+	// AccuracyRadius is the radius in kilometers around the coordinates the ip address is likely within, 0 if unknown
+	AccuracyRadius float64
+	// Attempts is how many requests it took to look up the location
+	Attempts int
+}
+
+// StatusError is returned by http based providers when the api responds with an error status code
+type StatusError struct {
+	Err        error
+	StatusCode int
+	// RetryAfter is the delay requested by the api's Retry-After header, 0 if not set
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// NewStatusError wraps err with the status code and Retry-After header of an http response
+func NewStatusError(err error, statusCode int, header http.Header) *StatusError {
+	return &StatusError{
+		Err:        err,
+		StatusCode: statusCode,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an http date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
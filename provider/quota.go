@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// quotaPeriodLayout names monthly quota periods, e.g. 2024-01
+	quotaPeriodLayout = "2006-01"
+	// quotaRetention is how long redis keeps a period's counter after the period ends
+	quotaRetention = 7 * 24 * time.Hour
+)
+
+// QuotaCounter counts provider requests per monthly period.
+// Periods are calendar months in UTC named like 2024-01.
+type QuotaCounter interface {
+	// Incr adds a request to the period and returns the new count
+	Incr(ctx context.Context, period string) (int64, error)
+	// Decr takes back a request added by Incr that wasn't made
+	Decr(ctx context.Context, period string) error
+	// Get returns the count of the period
+	Get(ctx context.Context, period string) (int64, error)
+}
+
+// quotaPeriod returns the name of the monthly period t falls in and when the period ends
+func quotaPeriod(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	end := time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	return t.Format(quotaPeriodLayout), end
+}
+
+// MemoryQuotaCounter counts requests in memory, so the quota is per process
+type MemoryQuotaCounter struct {
+	counts map[string]int64
+	mu     sync.Mutex
+}
+
+// NewMemoryQuotaCounter creates an in-memory quota counter
+func NewMemoryQuotaCounter() *MemoryQuotaCounter {
+	return &MemoryQuotaCounter{
+		counts: map[string]int64{},
+	}
+}
+
+// Incr adds a request to the period. Counts of other periods are dropped.
+func (m *MemoryQuotaCounter) Incr(ctx context.Context, period string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := range m.counts {
+		if p != period {
+			delete(m.counts, p)
+		}
+	}
+	m.counts[period]++
+	return m.counts[period], nil
+}
+
+// Decr takes back a request of the period
+func (m *MemoryQuotaCounter) Decr(ctx context.Context, period string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[period] > 0 {
+		m.counts[period]--
+	}
+	return nil
+}
+
+// Get returns the count of the period
+func (m *MemoryQuotaCounter) Get(ctx context.Context, period string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[period], nil
+}
+
+// RedisQuotaCounter counts requests in redis so the quota is shared by every instance using the same keys
+type RedisQuotaCounter struct {
+	redisClient redis.UniversalClient
+	keyPrefix   string
+}
+
+// NewRedisQuotaCounter counts requests in redis under keyPrefix followed by the period
+func NewRedisQuotaCounter(client redis.UniversalClient, keyPrefix string) *RedisQuotaCounter {
+	return &RedisQuotaCounter{
+		redisClient: client,
+		keyPrefix:   keyPrefix,
+	}
+}
+
+// Incr adds a request to the period. The counter expires a week after the period ends.
+func (r *RedisQuotaCounter) Incr(ctx context.Context, period string) (int64, error) {
+	start, err := time.Parse(quotaPeriodLayout, period)
+	if err != nil {
+		return 0, err
+	}
+	_, end := quotaPeriod(start)
+
+	var incr *redis.IntCmd
+	_, err = r.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, r.keyPrefix+period)
+		pipe.ExpireAt(ctx, r.keyPrefix+period, end.Add(quotaRetention))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Decr takes back a request of the period
+func (r *RedisQuotaCounter) Decr(ctx context.Context, period string) error {
+	return r.redisClient.Decr(ctx, r.keyPrefix+period).Err()
+}
+
+// Get returns the count of the period
+func (r *RedisQuotaCounter) Get(ctx context.Context, period string) (int64, error) {
+	count, err := r.redisClient.Get(ctx, r.keyPrefix+period).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaPeriod(t *testing.T) {
+	tests := []struct {
+		input          time.Time
+		expectedEnd    time.Time
+		expectedPeriod string
+	}{
+		{
+			input:          time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+			expectedPeriod: "2024-01",
+			expectedEnd:    time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			input:          time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			expectedPeriod: "2024-12",
+			expectedEnd:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		// Periods are in UTC
+		{
+			input:          time.Date(2024, 3, 1, 1, 0, 0, 0, time.FixedZone("CET", 2*60*60)),
+			expectedPeriod: "2024-02",
+			expectedEnd:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		period, end := quotaPeriod(test.input)
+		assert.Equal(t, test.expectedPeriod, period)
+		assert.Equal(t, test.expectedEnd, end)
+	}
+}
+
+func TestMemoryQuotaCounter(t *testing.T) {
+	counter := NewMemoryQuotaCounter()
+	ctx := context.TODO()
+
+	for i := int64(1); i <= 3; i++ {
+		count, err := counter.Incr(ctx, "2024-01")
+		assert.NoError(t, err)
+		assert.Equal(t, i, count)
+	}
+
+	// A new period starts from zero
+	count, err := counter.Incr(ctx, "2024-02")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = counter.Get(ctx, "2024-01")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, counter.Decr(ctx, "2024-02"))
+	assert.NoError(t, counter.Decr(ctx, "2024-02"))
+	count, err = counter.Get(ctx, "2024-02")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestRedisQuotaCounter(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	counter := NewRedisQuotaCounter(db, "geofence:quota:")
+	ctx := context.TODO()
+
+	mock.ExpectTxPipeline()
+	mock.ExpectIncr("geofence:quota:2024-01").SetVal(42)
+	mock.ExpectExpireAt("geofence:quota:2024-01", time.Date(2024, 2, 8, 0, 0, 0, 0, time.UTC)).SetVal(true)
+	mock.ExpectTxPipelineExec()
+
+	count, err := counter.Incr(ctx, "2024-01")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), count)
+
+	mock.ExpectGet("geofence:quota:2024-01").SetVal("42")
+	count, err = counter.Get(ctx, "2024-01")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), count)
+
+	mock.ExpectDecr("geofence:quota:2024-01").SetVal(41)
+	assert.NoError(t, counter.Decr(ctx, "2024-01"))
+
+	mock.ExpectGet("geofence:quota:2024-02").RedisNil()
+	count, err = counter.Get(ctx, "2024-02")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	_, err = counter.Incr(ctx, "not a period")
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"sort"
+)
+
+// RangeDatabase is a Provider looking up ip addresses offline in an in-memory index of ip ranges.
+// Ranges are kept sorted so lookups are a binary search.
+// The caller's own ip address can't be looked up, so the geofence center must be another address.
+type RangeDatabase struct {
+	name    string
+	v4      []v4Range
+	v6      []v6Range
+	records []rangeRecord
+}
+
+// rangeRecord is the location shared by one or more ranges
+type rangeRecord struct {
+	country   string
+	latitude  float64
+	longitude float64
+}
+
+type v4Range struct {
+	start  uint32
+	end    uint32
+	record uint32
+}
+
+// uint128 is an ipv6 address as a number
+type uint128 struct {
+	hi uint64
+	lo uint64
+}
+
+func (u uint128) less(v uint128) bool {
+	return u.hi < v.hi || (u.hi == v.hi && u.lo < v.lo)
+}
+
+type v6Range struct {
+	start  uint128
+	end    uint128
+	record uint32
+}
+
+// rangeBuilder collects ranges for a RangeDatabase, sharing records between ranges with the same location
+type rangeBuilder struct {
+	db      *RangeDatabase
+	records map[rangeRecord]uint32
+}
+
+func newRangeBuilder(name string) *rangeBuilder {
+	return &rangeBuilder{
+		db:      &RangeDatabase{name: name},
+		records: map[rangeRecord]uint32{},
+	}
+}
+
+// add adds the range of addresses from start to end, inclusive.
+// Ipv4-mapped ipv6 ranges are stored as ipv4.
+func (b *rangeBuilder) add(start, end netip.Addr, record rangeRecord) {
+	index, ok := b.records[record]
+	if !ok {
+		index = uint32(len(b.db.records))
+		b.db.records = append(b.db.records, record)
+		b.records[record] = index
+	}
+
+	start, end = start.Unmap(), end.Unmap()
+	if start.Is4() && end.Is4() {
+		b.db.v4 = append(b.db.v4, v4Range{
+			start:  addrToUint32(start),
+			end:    addrToUint32(end),
+			record: index,
+		})
+		return
+	}
+	b.db.v6 = append(b.db.v6, v6Range{
+		start:  addrToUint128(start),
+		end:    addrToUint128(end),
+		record: index,
+	})
+}
+
+// build sorts the ranges and returns the database
+func (b *rangeBuilder) build() *RangeDatabase {
+	sort.Slice(b.db.v4, func(i, j int) bool {
+		return b.db.v4[i].start < b.db.v4[j].start
+	})
+	sort.Slice(b.db.v6, func(i, j int) bool {
+		return b.db.v6[i].start.less(b.db.v6[j].start)
+	})
+	return b.db
+}
+
+func addrToUint32(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func addrToUint128(addr netip.Addr) uint128 {
+	b := addr.As16()
+	return uint128{
+		hi: binary.BigEndian.Uint64(b[:8]),
+		lo: binary.BigEndian.Uint64(b[8:]),
+	}
+}
+
+// Lookup finds the range containing the ip address.
+// Addresses outside every range, including the caller's own empty address, return ErrNotFound.
+func (d *RangeDatabase) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	addr, err := netip.ParseAddr(ipAddress)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	record, ok := d.find(addr)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &Location{
+		Provider:  d.name,
+		Country:   record.country,
+		Latitude:  record.latitude,
+		Longitude: record.longitude,
+		Attempts:  1,
+	}, nil
+}
+
+// Len returns the number of ranges in the database
+func (d *RangeDatabase) Len() int {
+	return len(d.v4) + len(d.v6)
+}
+
+// find binary searches for the last range starting at or before addr and checks it contains addr
+func (d *RangeDatabase) find(addr netip.Addr) (*rangeRecord, bool) {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		ip := addrToUint32(addr)
+		i := sort.Search(len(d.v4), func(i int) bool {
+			return d.v4[i].start > ip
+		}) - 1
+		if i < 0 || d.v4[i].end < ip {
+			return nil, false
+		}
+		return &d.records[d.v4[i].record], true
+	}
+
+	ip := addrToUint128(addr)
+	i := sort.Search(len(d.v6), func(i int) bool {
+		return ip.less(d.v6[i].start)
+	}) - 1
+	if i < 0 || d.v6[i].end.less(ip) {
+		return nil, false
+	}
+	return &d.records[d.v6[i].record], true
+}
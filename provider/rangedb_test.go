@@ -0,0 +1,293 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeDatabaseLookup(t *testing.T) {
+	dbipCSV := strings.Join([]string{
+		`1.0.0.0,1.0.0.255,OC,AU,Queensland,"South Brisbane",-27.4767,153.017`,
+		`8.8.8.0,8.8.8.255,NA,US,California,"Mountain View",37.4223,-122.085`,
+		`8.8.4.0,8.8.4.255,NA,US,California,"Mountain View",37.4223,-122.085`,
+		`10.0.0.0,10.255.255.255,ZZ,ZZ,,,0,0`,
+		`2001:4860::,2001:4860:ffff:ffff:ffff:ffff:ffff:ffff,NA,US,California,"Mountain View",37.4223,-122.085`,
+		`::ffff:9.9.9.0,::ffff:9.9.9.255,EU,CH,Zurich,Zurich,47.3769,8.54169`,
+	}, "\n")
+
+	ip2locationCSV := strings.Join([]string{
+		`"0","16777215","-","-","-","-","0.000000","0.000000"`,
+		`"16777216","16777471","AU","Australia","Queensland","South Brisbane","-27.476700","153.017000"`,
+		`"134744064","134744319","US","United States of America","California","Mountain View","37.422300","-122.085000"`,
+		`"134743040","134743295","US","United States of America","California","Mountain View","37.422300","-122.085000"`,
+		// 2001:4860:: to 2001:4860:ffff:ffff:ffff:ffff:ffff:ffff
+		`"42541956123769884636017138956568135680","42541956204998148047538203136064438271","US","United States of America","California","Mountain View","37.422300","-122.085000"`,
+		// ::ffff:9.9.9.0 to ::ffff:9.9.9.255
+		`"281470833330432","281470833330687","CH","Switzerland","Zurich","Zurich","47.376900","8.541690"`,
+	}, "\n")
+
+	dbip, err := LoadDBIPCSV(strings.NewReader(dbipCSV))
+	assert.NoError(t, err)
+	ip2locationFromCSV, err := LoadIP2LocationCSV(strings.NewReader(ip2locationCSV))
+	assert.NoError(t, err)
+	ip2locationFromBIN, err := LoadIP2LocationBIN(bytes.NewReader(buildIP2LocationBIN()))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		err       error
+		ipAddress string
+		country   string
+		latitude  float64
+		longitude float64
+	}{
+		{
+			ipAddress: "1.0.0.1",
+			country:   "AU",
+			latitude:  -27.4767,
+			longitude: 153.017,
+		},
+		{
+			ipAddress: "8.8.8.8",
+			country:   "US",
+			latitude:  37.4223,
+			longitude: -122.085,
+		},
+		// Ranges are sorted on load
+		{
+			ipAddress: "8.8.4.4",
+			country:   "US",
+			latitude:  37.4223,
+			longitude: -122.085,
+		},
+		{
+			ipAddress: "2001:4860:4860::8888",
+			country:   "US",
+			latitude:  37.4223,
+			longitude: -122.085,
+		},
+		// Ipv4-mapped addresses and ranges are ipv4
+		{
+			ipAddress: "::ffff:1.0.0.1",
+			country:   "AU",
+			latitude:  -27.4767,
+			longitude: 153.017,
+		},
+		{
+			ipAddress: "9.9.9.9",
+			country:   "CH",
+			latitude:  47.3769,
+			longitude: 8.54169,
+		},
+		// Between ranges
+		{
+			ipAddress: "8.8.6.1",
+			err:       ErrNotFound,
+		},
+		// Before the first range
+		{
+			ipAddress: "0.0.0.1",
+			err:       ErrNotFound,
+		},
+		// After the last range
+		{
+			ipAddress: "2a00::1",
+			err:       ErrNotFound,
+		},
+		// Unknown country
+		{
+			ipAddress: "10.0.0.1",
+			err:       ErrNotFound,
+		},
+		{
+			ipAddress: "",
+			err:       ErrNotFound,
+		},
+	}
+	for _, db := range []*RangeDatabase{dbip, ip2locationFromCSV, ip2locationFromBIN} {
+		for _, test := range tests {
+			location, err := db.Lookup(context.TODO(), test.ipAddress)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err, "%s %s", db.name, test.ipAddress)
+				continue
+			}
+			assert.NoError(t, err, "%s %s", db.name, test.ipAddress)
+			assert.Equal(t, &Location{
+				Provider:  db.name,
+				Country:   test.country,
+				Latitude:  test.latitude,
+				Longitude: test.longitude,
+				Attempts:  1,
+			}, location, "%s %s", db.name, test.ipAddress)
+		}
+	}
+
+	// Ranges with the same location share a record
+	assert.Equal(t, 5, dbip.Len())
+	assert.Len(t, dbip.records, 3)
+}
+
+func TestLoadRangeDatabaseInvalid(t *testing.T) {
+	_, err := LoadDBIPCSV(strings.NewReader("1.0.0.0,1.0.0.255,OC,AU\n"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = LoadDBIPCSV(strings.NewReader(`1.0.0.0,1.0.0.255,OC,AU,Queensland,"South Brisbane",north,153.017` + "\n"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = LoadDBIPCSV(strings.NewReader(`1.0.0,1.0.0.255,OC,AU,Queensland,"South Brisbane",-27.4767,153.017` + "\n"))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = LoadIP2LocationCSV(strings.NewReader(`"16777216","-1","AU","Australia","Queensland","South Brisbane","-27.476700","153.017000"` + "\n"))
+	assert.ErrorContains(t, err, "line 1")
+
+	// DB1 only has countries
+	bin := buildIP2LocationBIN()
+	bin[0] = 1
+	_, err = LoadIP2LocationBIN(bytes.NewReader(bin))
+	assert.ErrorIs(t, err, ErrUnsupportedDatabase)
+
+	_, err = LoadIP2LocationBIN(bytes.NewReader(buildIP2LocationBIN()[:100]))
+	assert.Error(t, err)
+}
+
+func TestLoadIP2LocationBINMalformedHeader(t *testing.T) {
+	tests := []struct {
+		modify func(bin []byte)
+		err    error
+	}{
+		// Too few columns for the coordinates of DB5
+		{modify: func(bin []byte) { bin[1] = 2 }, err: ErrUnsupportedDatabase},
+		{modify: func(bin []byte) { bin[1] = 0 }, err: ErrUnsupportedDatabase},
+		// More columns than the rows hold
+		{modify: func(bin []byte) { bin[1] = 255 }},
+		// A count that wraps around in 32 bits
+		{modify: func(bin []byte) { binary.LittleEndian.PutUint32(bin[5:], math.MaxUint32) }},
+		{modify: func(bin []byte) { binary.LittleEndian.PutUint32(bin[13:], math.MaxUint32) }},
+		// Tables starting outside the file
+		{modify: func(bin []byte) { binary.LittleEndian.PutUint32(bin[9:], math.MaxUint32) }},
+		{modify: func(bin []byte) { binary.LittleEndian.PutUint32(bin[17:], 0) }},
+		// A country pointer outside the file
+		{modify: func(bin []byte) {
+			binary.LittleEndian.PutUint32(bin[binary.LittleEndian.Uint32(bin[9:])-1+4:], math.MaxUint32)
+		}},
+	}
+	for i, test := range tests {
+		bin := buildIP2LocationBIN()
+		test.modify(bin)
+		assert.NotPanics(t, func() {
+			_, err := LoadIP2LocationBIN(bytes.NewReader(bin))
+			assert.Error(t, err, i)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err, i)
+			}
+		})
+	}
+}
+
+func TestParseIPNumber(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint128
+		err      bool
+	}{
+		{
+			input:    "16777216",
+			expected: uint128{lo: 16777216},
+		},
+		{
+			input:    "340282366920938463463374607431768211455",
+			expected: uint128{hi: math.MaxUint64, lo: math.MaxUint64},
+		},
+		{
+			input: "340282366920938463463374607431768211456",
+			err:   true,
+		},
+		{
+			input: "-1",
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		actual, err := parseIPNumber(test.input)
+		if test.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, actual)
+	}
+}
+
+// binRow is a row of a test IP2Location BIN database
+type binRow struct {
+	country   string
+	from      []byte
+	latitude  float32
+	longitude float32
+}
+
+// buildIP2LocationBIN builds a DB5 BIN database holding the same ranges as the csv test databases
+func buildIP2LocationBIN() []byte {
+	const columns = 6
+	ipv4Rows := []binRow{
+		{from: []byte{0, 0, 0, 0}, country: "-"},
+		{from: []byte{1, 0, 0, 0}, country: "AU", latitude: -27.4767, longitude: 153.017},
+		{from: []byte{1, 0, 1, 0}, country: "-"},
+		{from: []byte{8, 8, 4, 0}, country: "US", latitude: 37.4223, longitude: -122.085},
+		{from: []byte{8, 8, 5, 0}, country: "-"},
+		{from: []byte{8, 8, 8, 0}, country: "US", latitude: 37.4223, longitude: -122.085},
+		{from: []byte{8, 8, 9, 0}, country: "-"},
+		{from: []byte{9, 9, 9, 0}, country: "CH", latitude: 47.3769, longitude: 8.54169},
+		{from: []byte{9, 9, 10, 0}, country: "-"},
+		// Marks the end of the last row
+		{from: []byte{255, 255, 255, 255}},
+	}
+	ipv6Rows := []binRow{
+		{from: []byte{0x20, 0x01, 0x48, 0x60, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, country: "US", latitude: 37.4223, longitude: -122.085},
+		{from: []byte{0x20, 0x01, 0x48, 0x61, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, country: "-"},
+		{from: bytes.Repeat([]byte{0xff}, 16)},
+	}
+
+	buf := make([]byte, ip2locationHeaderSize)
+	pointers := map[string]uint32{}
+	writeRows := func(rows []binRow) uint32 {
+		addr := uint32(len(buf)) + 1
+		for _, row := range rows {
+			// ip_from is little endian
+			from := make([]byte, len(row.from))
+			for i := range row.from {
+				from[len(from)-1-i] = row.from[i]
+			}
+			buf = append(buf, from...)
+			// country pointer, region, city, latitude, longitude
+			fields := make([]byte, (columns-1)*4)
+			binary.LittleEndian.PutUint32(fields[0:], pointers[row.country])
+			binary.LittleEndian.PutUint32(fields[12:], math.Float32bits(row.latitude))
+			binary.LittleEndian.PutUint32(fields[16:], math.Float32bits(row.longitude))
+			buf = append(buf, fields...)
+		}
+		return addr
+	}
+
+	// Strings are stored after the header and referenced by 0-based pointers
+	for _, country := range []string{"-", "AU", "US", "CH"} {
+		pointers[country] = uint32(len(buf))
+		buf = append(buf, byte(len(country)))
+		buf = append(buf, country...)
+	}
+	pointers[""] = pointers["-"]
+
+	ipv4Addr := writeRows(ipv4Rows)
+	ipv6Addr := writeRows(ipv6Rows)
+
+	buf[0] = 5
+	buf[1] = columns
+	binary.LittleEndian.PutUint32(buf[5:], uint32(len(ipv4Rows)-1))
+	binary.LittleEndian.PutUint32(buf[9:], ipv4Addr)
+	binary.LittleEndian.PutUint32(buf[13:], uint32(len(ipv6Rows)-1))
+	binary.LittleEndian.PutUint32(buf[17:], ipv6Addr)
+	return buf
+}
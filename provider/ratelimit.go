@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitInterval = time.Minute
+)
+
+var (
+	// ErrRateLimited is returned by RateLimiter when no request is available and ExhaustedFail is set
+	ErrRateLimited = errors.New("provider rate limit exceeded")
+	// ErrQuotaExhausted is returned by RateLimiter once the monthly quota is used up
+	ErrQuotaExhausted = errors.New("provider monthly quota exhausted")
+)
+
+// ExhaustedBehavior controls what RateLimiter does when the rate limit or quota is exhausted
+type ExhaustedBehavior int
+
+const (
+	// ExhaustedWait queues lookups until the rate limit allows them, or the context is done.
+	// There is no waiting for the monthly quota, which fails with ErrQuotaExhausted. This is the default.
+	ExhaustedWait ExhaustedBehavior = iota
+	// ExhaustedFail fails lookups with ErrRateLimited or ErrQuotaExhausted
+	ExhaustedFail
+	// ExhaustedFallback sends lookups to RateLimitOptions.Fallback
+	ExhaustedFallback
+)
+
+// RateLimitOptions configures a RateLimiter
+type RateLimitOptions struct {
+	// Fallback looks up ip addresses when the budget is exhausted with ExhaustedFallback
+	Fallback Provider
+	// QuotaCounter counts requests against MonthlyQuota. Defaults to counting in memory,
+	// use a RedisQuotaCounter to share the quota between instances.
+	// Geofence.Close closes it if it implements io.Closer.
+	QuotaCounter QuotaCounter
+	// Limit is how many requests are allowed per Interval. Unlimited if <= 0.
+	Limit int
+	// Interval defaults to a minute
+	Interval time.Duration
+	// Burst is how many requests can be made at once. Defaults to Limit.
+	Burst int
+	// MonthlyQuota is how many requests are allowed per calendar month in UTC. Unlimited if <= 0.
+	MonthlyQuota int64
+	Exhausted    ExhaustedBehavior
+}
+
+// RateLimiter is a Provider that limits the requests made to another Provider
+// with a token bucket and a monthly quota.
+type RateLimiter struct {
+	provider Provider
+	bucket   *tokenBucket
+	// now is replaced in tests
+	now  func() time.Time
+	opts RateLimitOptions
+}
+
+// NewRateLimiter wraps p with the rate limit and quota configured by opts.
+func NewRateLimiter(p Provider, opts *RateLimitOptions) *RateLimiter {
+	r := &RateLimiter{
+		provider: p,
+		opts:     *opts,
+		now:      time.Now,
+	}
+	if r.opts.QuotaCounter == nil {
+		r.opts.QuotaCounter = NewMemoryQuotaCounter()
+	}
+	if r.opts.Limit > 0 {
+		if r.opts.Interval <= 0 {
+			r.opts.Interval = defaultRateLimitInterval
+		}
+		if r.opts.Burst <= 0 {
+			r.opts.Burst = r.opts.Limit
+		}
+		r.bucket = newTokenBucket(float64(r.opts.Limit)/r.opts.Interval.Seconds(), float64(r.opts.Burst), r.now())
+	}
+	return r
+}
+
+// Lookup looks up the ip address once the rate limit and quota allow it.
+func (r *RateLimiter) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	if r.bucket != nil {
+		err := r.wait(ctx)
+		if errors.Is(err, ErrRateLimited) {
+			return r.exhausted(ctx, ipAddress, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.opts.MonthlyQuota > 0 {
+		period, _ := quotaPeriod(r.now())
+		count, err := r.opts.QuotaCounter.Incr(ctx, period)
+		if err != nil {
+			return nil, err
+		}
+		if count > r.opts.MonthlyQuota {
+			// Only requests sent to the provider count against the quota.
+			// Counting first and taking refused requests back keeps a shared counter from being overrun.
+			if err := r.opts.QuotaCounter.Decr(ctx, period); err != nil {
+				return nil, err
+			}
+			return r.exhausted(ctx, ipAddress, ErrQuotaExhausted)
+		}
+	}
+
+	return r.provider.Lookup(ctx, ipAddress)
+}
+
+// wait takes a token from the bucket, waiting for one with ExhaustedWait
+func (r *RateLimiter) wait(ctx context.Context) error {
+	if r.opts.Exhausted != ExhaustedWait {
+		if !r.bucket.take(r.now()) {
+			return ErrRateLimited
+		}
+		return nil
+	}
+
+	delay := r.bucket.reserve(r.now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// Give the token back to the lookups queued behind this one
+		r.bucket.refund()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// exhausted applies the exhausted behavior once the budget is used up
+func (r *RateLimiter) exhausted(ctx context.Context, ipAddress string, err error) (*Location, error) {
+	if r.opts.Exhausted == ExhaustedFallback && r.opts.Fallback != nil {
+		return r.opts.Fallback.Lookup(ctx, ipAddress)
+	}
+	return nil, err
+}
+
+// QuotaUsage returns how many requests were made in the current month
+func (r *RateLimiter) QuotaUsage(ctx context.Context) (int64, error) {
+	period, _ := quotaPeriod(r.now())
+	return r.opts.QuotaCounter.Get(ctx, period)
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens.
+// Tokens go negative when reserved ahead of time, so waiting lookups are served in order.
+type tokenBucket struct {
+	last   time.Time
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		last:   now,
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+// refill adds the tokens accumulated since the last call, b.mu must be held
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// take takes a token if one is available
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token and returns how long to wait until it's available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund returns a reserved token that wasn't used
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// 1 token per second, up to 2
+	bucket := newTokenBucket(1, 2, now)
+
+	assert.True(t, bucket.take(now))
+	assert.True(t, bucket.take(now))
+	assert.False(t, bucket.take(now))
+
+	// Refills over time but never above burst
+	now = now.Add(time.Second)
+	assert.True(t, bucket.take(now))
+	assert.False(t, bucket.take(now))
+	now = now.Add(time.Hour)
+	assert.True(t, bucket.take(now))
+	assert.True(t, bucket.take(now))
+	assert.False(t, bucket.take(now))
+
+	// Reservations queue up behind each other
+	assert.Equal(t, time.Second, bucket.reserve(now))
+	assert.Equal(t, 2*time.Second, bucket.reserve(now))
+	bucket.refund()
+	assert.Equal(t, 2*time.Second, bucket.reserve(now))
+}
+
+func TestRateLimiter(t *testing.T) {
+	primaryCalls, fallbackCalls := 0, 0
+	primary := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		primaryCalls++
+		return &Location{Latitude: 1}, nil
+	})
+	fallback := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		fallbackCalls++
+		return &Location{Latitude: 2}, nil
+	})
+
+	tests := []struct {
+		err              error
+		opts             RateLimitOptions
+		lookups          int
+		expectedPrimary  int
+		expectedFallback int
+	}{
+		// Unlimited
+		{
+			lookups:         5,
+			expectedPrimary: 5,
+		},
+		{
+			opts: RateLimitOptions{
+				Limit:     2,
+				Exhausted: ExhaustedFail,
+			},
+			lookups:         3,
+			expectedPrimary: 2,
+			err:             ErrRateLimited,
+		},
+		{
+			opts: RateLimitOptions{
+				MonthlyQuota: 2,
+				Exhausted:    ExhaustedWait,
+			},
+			lookups:         3,
+			expectedPrimary: 2,
+			err:             ErrQuotaExhausted,
+		},
+		{
+			opts: RateLimitOptions{
+				Limit:        10,
+				MonthlyQuota: 3,
+				Exhausted:    ExhaustedFallback,
+				Fallback:     fallback,
+			},
+			lookups:          5,
+			expectedPrimary:  3,
+			expectedFallback: 2,
+		},
+	}
+	for _, test := range tests {
+		primaryCalls, fallbackCalls = 0, 0
+		limiter := NewRateLimiter(primary, &test.opts)
+
+		var err error
+		for i := 0; i < test.lookups; i++ {
+			_, err = limiter.Lookup(context.TODO(), "8.8.8.8")
+		}
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.expectedPrimary, primaryCalls)
+		assert.Equal(t, test.expectedFallback, fallbackCalls)
+
+		if test.opts.MonthlyQuota > 0 {
+			usage, err := limiter.QuotaUsage(context.TODO())
+			assert.NoError(t, err)
+			// Refused lookups don't count
+			assert.Equal(t, int64(test.expectedPrimary), usage)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	p := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		return &Location{}, nil
+	})
+	limiter := NewRateLimiter(p, &RateLimitOptions{
+		Limit:    1,
+		Interval: 50 * time.Millisecond,
+	})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := limiter.Lookup(context.TODO(), "8.8.8.8")
+		assert.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// Gives up when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err := limiter.Lookup(ctx, "8.8.8.8")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
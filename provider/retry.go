@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+var (
+	defaultRetryableStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+// RetryPolicy configures how failed lookups are retried.
+// Lookups failing with a StatusError are only retried for RetryableStatusCodes,
+// other errors such as connection resets are retried unless the provider answered,
+// like ErrNotFound, or the lookup was refused, like ErrQuotaExhausted.
+type RetryPolicy struct {
+	// RetryableStatusCodes defaults to 429, 500, 502, 503 and 504
+	RetryableStatusCodes []int
+	// MaxAttempts is the total number of attempts, including the first. Defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Defaults to 5s.
+	// A Retry-After longer than MaxBackoff stops retrying.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each retry. Defaults to 2.
+	Multiplier float64
+	// Jitter randomly shortens each delay by up to this fraction, between 0 and 1,
+	// so clients don't retry in lockstep
+	Jitter float64
+}
+
+// RetryStats holds retry counters
+type RetryStats struct {
+	// Lookups counts calls to Lookup
+	Lookups uint64
+	// Retries counts attempts after the first
+	Retries uint64
+	// Failures counts lookups that failed after all attempts
+	Failures uint64
+}
+
+// Retrier is a Provider that retries failed lookups of another Provider.
+type Retrier struct {
+	provider Provider
+	policy   RetryPolicy
+	lookups  uint64
+	retries  uint64
+	failures uint64
+}
+
+// NewRetrier wraps p so failed lookups are retried according to policy.
+func NewRetrier(p Provider, policy *RetryPolicy) *Retrier {
+	r := &Retrier{
+		provider: p,
+		policy:   *policy,
+	}
+	if r.policy.MaxAttempts <= 0 {
+		r.policy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if r.policy.InitialBackoff <= 0 {
+		r.policy.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if r.policy.MaxBackoff <= 0 {
+		r.policy.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if r.policy.Multiplier < 1 {
+		r.policy.Multiplier = defaultRetryMultiplier
+	}
+	if r.policy.RetryableStatusCodes == nil {
+		r.policy.RetryableStatusCodes = defaultRetryableStatusCodes
+	}
+	return r
+}
+
+// Lookup looks up the ip address, retrying failures with exponential backoff.
+func (r *Retrier) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	atomic.AddUint64(&r.lookups, 1)
+
+	backoff := r.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		location, err := r.provider.Lookup(ctx, ipAddress)
+		if err == nil {
+			// The provider may return a location it keeps, such as a fixed one
+			counted := *location
+			counted.Attempts += attempt - 1
+			if counted.Attempts < attempt {
+				counted.Attempts = attempt
+			}
+			return &counted, nil
+		}
+
+		delay, retry := r.delay(ctx, err, backoff)
+		if !retry || attempt >= r.policy.MaxAttempts {
+			atomic.AddUint64(&r.failures, 1)
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			atomic.AddUint64(&r.failures, 1)
+			return nil, err
+		case <-timer.C:
+		}
+
+		atomic.AddUint64(&r.retries, 1)
+		backoff = time.Duration(float64(backoff) * r.policy.Multiplier)
+	}
+}
+
+// delay returns how long to wait before retrying err, or false if it shouldn't be retried
+func (r *Retrier) delay(ctx context.Context, err error, backoff time.Duration) (time.Duration, bool) {
+	if ctx.Err() != nil {
+		return 0, false
+	}
+
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		if !r.isRetryableStatusCode(statusErr.StatusCode) {
+			return 0, false
+		}
+		if statusErr.RetryAfter > 0 {
+			return statusErr.RetryAfter, statusErr.RetryAfter <= r.policy.MaxBackoff
+		}
+	case !errors.Is(err, ErrRateLimited) && !isUnavailable(err):
+		return 0, false
+	}
+
+	if backoff > r.policy.MaxBackoff {
+		backoff = r.policy.MaxBackoff
+	}
+	if r.policy.Jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * r.policy.Jitter * float64(backoff))
+	}
+	return backoff, true
+}
+
+func (r *Retrier) isRetryableStatusCode(statusCode int) bool {
+	for _, code := range r.policy.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the retry counters.
+func (r *Retrier) Stats() RetryStats {
+	return RetryStats{
+		Lookups:  atomic.LoadUint64(&r.lookups),
+		Retries:  atomic.LoadUint64(&r.retries),
+		Failures: atomic.LoadUint64(&r.failures),
+	}
+}
@@ -0,0 +1,180 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider returns its errors in order, then its location
+type fakeProvider struct {
+	location *Location
+	errs     []error
+	calls    int
+}
+
+func (f *fakeProvider) Lookup(ctx context.Context, ipAddress string) (*Location, error) {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return nil, f.errs[f.calls-1]
+	}
+	location := *f.location
+	return &location, nil
+}
+
+func TestRetrier(t *testing.T) {
+	errReset := errors.New("connection reset by peer")
+	tests := []struct {
+		errs             []error
+		policy           RetryPolicy
+		expectedCalls    int
+		expectedAttempts int
+		err              bool
+	}{
+		// Succeeds first time
+		{
+			expectedCalls:    1,
+			expectedAttempts: 1,
+		},
+		// Retries transport errors and 5xx
+		{
+			errs:             []error{errReset, &StatusError{Err: errReset, StatusCode: http.StatusBadGateway}},
+			expectedCalls:    3,
+			expectedAttempts: 3,
+		},
+		// Gives up after MaxAttempts
+		{
+			errs:          []error{errReset, errReset, errReset, errReset},
+			policy:        RetryPolicy{MaxAttempts: 2},
+			expectedCalls: 2,
+			err:           true,
+		},
+		// Doesn't retry client errors
+		{
+			errs:          []error{&StatusError{Err: errReset, StatusCode: http.StatusUnauthorized}},
+			expectedCalls: 1,
+			err:           true,
+		},
+		// Honours Retry-After
+		{
+			errs:             []error{&StatusError{Err: errReset, StatusCode: http.StatusTooManyRequests, RetryAfter: time.Millisecond}},
+			expectedCalls:    2,
+			expectedAttempts: 2,
+		},
+		// Retry-After longer than MaxBackoff stops retrying
+		{
+			errs:          []error{&StatusError{Err: errReset, StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}},
+			expectedCalls: 1,
+			err:           true,
+		},
+		// Custom retryable status codes
+		{
+			errs:          []error{&StatusError{Err: errReset, StatusCode: http.StatusBadGateway}},
+			policy:        RetryPolicy{RetryableStatusCodes: []int{http.StatusServiceUnavailable}},
+			expectedCalls: 1,
+			err:           true,
+		},
+	}
+	for _, test := range tests {
+		fake := &fakeProvider{
+			errs:     test.errs,
+			location: &Location{Latitude: 1, Longitude: 2},
+		}
+		policy := test.policy
+		policy.InitialBackoff = time.Millisecond
+		policy.MaxBackoff = 10 * time.Millisecond
+		policy.Jitter = 0.5
+		retrier := NewRetrier(fake, &policy)
+
+		location, err := retrier.Lookup(context.TODO(), "8.8.8.8")
+		assert.Equal(t, test.expectedCalls, fake.calls)
+		if test.err {
+			assert.Error(t, err)
+			assert.Nil(t, location)
+			assert.Equal(t, uint64(1), retrier.Stats().Failures)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, 1.0, location.Latitude)
+			assert.Equal(t, test.expectedAttempts, location.Attempts)
+		}
+		assert.Equal(t, RetryStats{
+			Lookups:  1,
+			Retries:  uint64(test.expectedCalls - 1),
+			Failures: retrier.Stats().Failures,
+		}, retrier.Stats())
+	}
+}
+
+func TestRetrierContextCanceled(t *testing.T) {
+	fake := &fakeProvider{
+		errs:     []error{errors.New("connection reset by peer")},
+		location: &Location{},
+	}
+	retrier := NewRetrier(fake, &RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := retrier.Lookup(ctx, "8.8.8.8")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 1, fake.calls)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{
+			input:    "",
+			expected: 0,
+		},
+		{
+			input:    "120",
+			expected: 2 * time.Minute,
+		},
+		{
+			input:    "-1",
+			expected: 0,
+		},
+		{
+			input:    now.Add(30 * time.Second).Format(http.TimeFormat),
+			expected: 30 * time.Second,
+		},
+		{
+			input:    "soon",
+			expected: 0,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, parseRetryAfter(test.input, now))
+	}
+}
+
+func TestRetrierKeepsProviderLocation(t *testing.T) {
+	// The provider's location is shared between lookups and must not be modified
+	shared := &Location{Latitude: 1, Longitude: 2}
+	calls := 0
+	p := Func(func(ctx context.Context, ipAddress string) (*Location, error) {
+		calls++
+		if calls%2 == 1 {
+			return nil, errors.New("connection reset by peer")
+		}
+		return shared, nil
+	})
+	retrier := NewRetrier(p, &RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		location, err := retrier.Lookup(context.TODO(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, location.Attempts)
+	}
+	assert.Zero(t, shared.Attempts)
+}
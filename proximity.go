@@ -0,0 +1,56 @@
+package geofence
+
+// Proximity is where a located ip address lies relative to the geofence, taking the accuracy of its location into account
+type Proximity string
+
+const (
+	// ProximityInside means the whole accuracy radius around the location is within the geofence
+	ProximityInside Proximity = "inside"
+	// ProximityOutside means the whole accuracy radius around the location is outside the geofence
+	ProximityOutside Proximity = "outside"
+	// ProximityUncertain means the accuracy radius around the location overlaps the edge of the geofence,
+	// so the ip address may be on either side. UncertainPolicy decides if it's near.
+	ProximityUncertain Proximity = "uncertain"
+)
+
+// UncertainPolicy controls how IsIPAddressNear answers for ProximityUncertain locations
+type UncertainPolicy int
+
+const (
+	// UncertainPolicyPoint compares the coordinates of the location as an exact point, ignoring its accuracy radius.
+	// This is the default.
+	UncertainPolicyPoint UncertainPolicy = iota
+	// UncertainPolicyOpen treats uncertain locations as near
+	UncertainPolicyOpen
+	// UncertainPolicyClosed treats uncertain locations as not near
+	UncertainPolicyClosed
+)
+
+// proximity classifies a location at distance from the center with an accuracy radius, all in the same unit.
+// Locations with an unknown accuracy radius of 0 are exact points, so never uncertain.
+func proximity(distance, accuracyRadius, radius float64) Proximity {
+	switch {
+	case distance+accuracyRadius <= radius:
+		return ProximityInside
+	case distance-accuracyRadius > radius:
+		return ProximityOutside
+	default:
+		return ProximityUncertain
+	}
+}
+
+// near decides if a location at distance from the center is near
+func (p UncertainPolicy) near(proximity Proximity, distance, radius float64) bool {
+	switch {
+	case proximity == ProximityInside:
+		return true
+	case proximity == ProximityOutside:
+		return false
+	case p == UncertainPolicyOpen:
+		return true
+	case p == UncertainPolicyClosed:
+		return false
+	default:
+		return distance <= radius
+	}
+}
@@ -0,0 +1,137 @@
+package geofence
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProximity(t *testing.T) {
+	tests := []struct {
+		expected       Proximity
+		distance       float64
+		accuracyRadius float64
+		radius         float64
+	}{
+		{distance: 5, radius: 10, expected: ProximityInside},
+		{distance: 10, radius: 10, expected: ProximityInside},
+		{distance: 11, radius: 10, expected: ProximityOutside},
+		{distance: 5, accuracyRadius: 5, radius: 10, expected: ProximityInside},
+		{distance: 5, accuracyRadius: 6, radius: 10, expected: ProximityUncertain},
+		{distance: 15, accuracyRadius: 6, radius: 10, expected: ProximityUncertain},
+		{distance: 15, accuracyRadius: 5, radius: 10, expected: ProximityUncertain},
+		{distance: 16, accuracyRadius: 5, radius: 10, expected: ProximityOutside},
+		{distance: 0, accuracyRadius: 100, radius: 1, expected: ProximityUncertain},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, proximity(test.distance, test.accuracyRadius, test.radius), test)
+	}
+}
+
+func TestGeofenceUncertainPolicy(t *testing.T) {
+	// Paris, 5km from the center and accurate to 20km
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 48.8566, Longitude: 2.3522, AccuracyRadius: 20}, nil
+	})
+	center := &Coordinates{Latitude: 48.8566, Longitude: 2.2839}
+
+	tests := []struct {
+		unit              Unit
+		expectedProximity Proximity
+		policy            UncertainPolicy
+		radius            float64
+		expectedNear      bool
+	}{
+		{radius: 30, expectedProximity: ProximityInside, expectedNear: true},
+		{radius: 30, policy: UncertainPolicyClosed, expectedProximity: ProximityInside, expectedNear: true},
+		{radius: 10, expectedProximity: ProximityUncertain, expectedNear: true},
+		{radius: 10, policy: UncertainPolicyClosed, expectedProximity: ProximityUncertain},
+		{radius: 1, expectedProximity: ProximityUncertain},
+		{radius: 1, policy: UncertainPolicyOpen, expectedProximity: ProximityUncertain, expectedNear: true},
+		// The accuracy radius is converted to the unit of the radius
+		{radius: 10000, unit: UnitMeters, policy: UncertainPolicyClosed, expectedProximity: ProximityUncertain},
+		{radius: 26000, unit: UnitMeters, policy: UncertainPolicyClosed, expectedProximity: ProximityInside, expectedNear: true},
+	}
+	for _, test := range tests {
+		geofence, err := New(&Config{
+			Provider:        fakeProvider,
+			Center:          center,
+			Radius:          test.radius,
+			Unit:            test.unit,
+			UncertainPolicy: test.policy,
+			CacheTTL:        -1,
+		})
+		assert.NoError(t, err)
+
+		decision, err := geofence.Check("8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedProximity, decision.Proximity, test)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear, test)
+
+		// The proximity is cached along with the answer
+		decision, err = geofence.Check("8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, SourceCache, decision.Source)
+		assert.Equal(t, test.expectedProximity, decision.Proximity)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear)
+	}
+
+	_, err := New(&Config{Provider: fakeProvider, UncertainPolicy: UncertainPolicy(42)})
+	assert.ErrorIs(t, err, ErrInvalidUncertainPolicy)
+}
+
+func TestGeofenceDefaultAccuracyRadius(t *testing.T) {
+	fakeApiToken := "fakeApiToken"
+	ipAddress := "8.8.8.8"
+	// Paris, 5km from the center
+	response := &ipbaseResponse{Data: data{Location: location{Latitude: 48.8566, Longitude: 2.3522}}}
+	center := &Coordinates{Latitude: 48.8566, Longitude: 2.2839}
+
+	tests := []struct {
+		unit                  Unit
+		expectedProximity     Proximity
+		defaultAccuracyRadius float64
+		radius                float64
+		expectedNear          bool
+	}{
+		{radius: 10, expectedProximity: ProximityInside, expectedNear: true},
+		{radius: 10, defaultAccuracyRadius: 20, expectedProximity: ProximityUncertain},
+		{radius: 30, defaultAccuracyRadius: 20, expectedProximity: ProximityInside, expectedNear: true},
+		// The default accuracy radius is in the unit of the radius, 10mi is 16km
+		{radius: 10, defaultAccuracyRadius: 10, unit: UnitMiles, expectedProximity: ProximityUncertain},
+		{radius: 20, defaultAccuracyRadius: 10, unit: UnitMiles, expectedProximity: ProximityInside, expectedNear: true},
+	}
+	for _, test := range tests {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, ipAddress),
+			httpmock.NewJsonResponderOrPanic(200, response))
+		geofence, err := New(&Config{
+			Token:                 fakeApiToken,
+			Transport:             transport,
+			Center:                center,
+			Radius:                test.radius,
+			Unit:                  test.unit,
+			DefaultAccuracyRadius: test.defaultAccuracyRadius,
+			UncertainPolicy:       UncertainPolicyClosed,
+		})
+		assert.NoError(t, err)
+
+		decision, err := geofence.Check(ipAddress)
+		assert.NoError(t, err)
+		assert.Equal(t, SourceLookup, decision.Source)
+		assert.Equal(t, test.expectedProximity, decision.Proximity, test)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear, test)
+		assert.InDelta(t, test.unit.ToKilometers(test.defaultAccuracyRadius), decision.Location.AccuracyRadius, 1e-9)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	}
+
+	for _, radius := range []float64{-1, math.NaN(), math.Inf(1)} {
+		_, err := New(&Config{Token: fakeApiToken, DefaultAccuracyRadius: radius})
+		assert.ErrorIs(t, err, ErrInvalidRadius)
+	}
+}
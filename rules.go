@@ -0,0 +1,163 @@
+package geofence
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+
+	"github.com/circa10a/go-geofence/cache"
+)
+
+// Rules are the parts of the configuration that decide ip addresses. They can be replaced with Reload while the geofence runs.
+// The fields mean the same as in Config.
+type Rules struct {
+	Center           *Coordinates
+	Overrides        *Overrides
+	SpecialAddresses map[AddressCategory]SpecialAddressPolicy
+	// fingerprint identifies the geofence of the rules and center, set when either changes
+	fingerprint     string
+	Place           string
+	Unit            Unit
+	DistanceFormula DistanceFormula
+	// center is the center the fingerprint was computed for
+	center                  Coordinates
+	Radius                  float64
+	DefaultAccuracyRadius   float64
+	FailurePolicy           FailurePolicy
+	UncertainPolicy         UncertainPolicy
+	AllowPrivateIPAddresses bool
+}
+
+// rules returns the rules of the configuration
+func (c *Config) rules() *Rules {
+	return &Rules{
+		Center:                  c.Center,
+		Overrides:               c.Overrides,
+		SpecialAddresses:        c.SpecialAddresses,
+		Place:                   c.Place,
+		Unit:                    c.Unit,
+		DistanceFormula:         c.DistanceFormula,
+		Radius:                  c.Radius,
+		DefaultAccuracyRadius:   c.DefaultAccuracyRadius,
+		FailurePolicy:           c.FailurePolicy,
+		UncertainPolicy:         c.UncertainPolicy,
+		AllowPrivateIPAddresses: c.AllowPrivateIPAddresses,
+	}
+}
+
+// validate ensures the rules make sense
+func (r *Rules) validate() error {
+	if r.Radius < 0 || math.IsNaN(r.Radius) || math.IsInf(r.Radius, 0) {
+		return fmt.Errorf("%w: %v", ErrInvalidRadius, r.Radius)
+	}
+	if r.DefaultAccuracyRadius < 0 || math.IsNaN(r.DefaultAccuracyRadius) || math.IsInf(r.DefaultAccuracyRadius, 0) {
+		return fmt.Errorf("%w: default accuracy radius %v", ErrInvalidRadius, r.DefaultAccuracyRadius)
+	}
+	if r.Center != nil && !r.Center.valid() {
+		return fmt.Errorf("%w: center %v", ErrInvalidCoordinates, *r.Center)
+	}
+	if !r.Unit.valid() {
+		return fmt.Errorf("%w: %q, expected km, mi, nmi or m", ErrInvalidUnit, r.Unit)
+	}
+	if !r.DistanceFormula.valid() {
+		return fmt.Errorf("%w: %q, expected haversine or vincenty", ErrInvalidDistanceFormula, r.DistanceFormula)
+	}
+	if r.FailurePolicy < FailurePolicyError || r.FailurePolicy > FailurePolicyServeStale {
+		return fmt.Errorf("%w: %d", ErrInvalidFailurePolicy, r.FailurePolicy)
+	}
+	if r.UncertainPolicy < UncertainPolicyPoint || r.UncertainPolicy > UncertainPolicyClosed {
+		return fmt.Errorf("%w: %d", ErrInvalidUncertainPolicy, r.UncertainPolicy)
+	}
+	return validateSpecialAddresses(r.SpecialAddresses)
+}
+
+// currentRules returns the rules in effect, which must not be modified
+func (g *Geofence) currentRules() *Rules {
+	return g.rules.Load().(*Rules)
+}
+
+// Rules returns the rules in effect. Its Overrides and SpecialAddresses must not be modified.
+func (g *Geofence) Rules() Rules {
+	return *g.currentRules()
+}
+
+// Reload atomically replaces the rules. Checks in flight finish with the rules they started with.
+// A Center or Place moves the center of the geofence, otherwise the current center is kept.
+// Cached results decided against a different center, radius, unit, distance formula, default accuracy radius or uncertain policy
+// are ignored from then on, including results of checks still in flight, and replaced as addresses are looked up again.
+// Invalid rules return an error and the current rules stay in effect.
+func (g *Geofence) Reload(rules Rules) error {
+	err := rules.validate()
+	if err != nil {
+		return err
+	}
+
+	var center *Coordinates
+	switch {
+	case rules.Center != nil:
+		coordinates := *rules.Center
+		center = &coordinates
+	case rules.Place != "":
+		coordinates, err := LookupPlace(rules.Place)
+		if err != nil {
+			return err
+		}
+		center = &coordinates
+	}
+
+	g.reloading.Lock()
+	defer g.reloading.Unlock()
+
+	if center != nil {
+		g.moveCenter(*center)
+	}
+	g.storeRules(&rules)
+	return nil
+}
+
+// fixedCenter returns whether the rules set the center, which then isn't looked up
+func (r *Rules) fixedCenter() bool {
+	return r.Center != nil || r.Place != ""
+}
+
+// storeRules puts a copy of rules in effect along with the fingerprint of the current center.
+// The caller must hold g.reloading once the geofence runs.
+func (g *Geofence) storeRules(rules *Rules) {
+	stored := *rules
+	stored.center = g.Center()
+	stored.fingerprint = stored.fingerprintAt(stored.center)
+	g.rules.Store(&stored)
+}
+
+// fingerprintFor returns the fingerprint of the rules with center. The one stored with the rules is used unless
+// the center moved without setCenter, such as by writing Latitude and Longitude.
+func (r *Rules) fingerprintFor(center Coordinates) string {
+	if center != r.center {
+		return r.fingerprintAt(center)
+	}
+	return r.fingerprint
+}
+
+// fingerprintAt identifies the geofence a result is decided against, so results of another geofence aren't served
+func (r *Rules) fingerprintAt(center Coordinates) string {
+	unit := r.Unit
+	if unit == "" {
+		unit = UnitKilometers
+	}
+	formula := r.DistanceFormula
+	if formula == "" {
+		formula = FormulaHaversine
+	}
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%v,%v,%v,%s,%s,%d,%v", center.Latitude, center.Longitude, r.Radius, unit, formula, r.UncertainPolicy,
+		r.DefaultAccuracyRadius)
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// decidedBy reports whether a cached entry was decided against the geofence of the rules.
+// Entries stored by older versions have no fingerprint and are trusted.
+func (g *Geofence) decidedBy(entry *cache.Entry, rules *Rules) bool {
+	return entry.Fingerprint == "" || entry.Fingerprint == rules.fingerprintFor(g.Center())
+}
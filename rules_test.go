@@ -0,0 +1,138 @@
+package geofence
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeofenceReload(t *testing.T) {
+	lookups := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups++
+		// London, about 340km from Paris
+		return &provider.Location{Latitude: 51.5072, Longitude: -0.1276}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider: fakeProvider,
+		Place:    "Paris, FR",
+		Radius:   100,
+		CacheTTL: -1,
+	})
+	assert.NoError(t, err)
+
+	decision, err := geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.False(t, decision.IsIPAddressNear)
+	assert.Equal(t, 1, lookups)
+
+	tests := []struct {
+		err            error
+		expectedSource Source
+		rules          Rules
+		expectedLookup int
+		expectedNear   bool
+	}{
+		// Invalid rules keep the current ones
+		{rules: Rules{Radius: -1}, err: ErrInvalidRadius, expectedSource: SourceCache, expectedLookup: 1},
+		{rules: Rules{Place: "Atlantis"}, err: ErrUnknownPlace, expectedSource: SourceCache, expectedLookup: 1},
+		// Results decided against a smaller radius are looked up again
+		{rules: Rules{Radius: 500}, expectedSource: SourceLookup, expectedNear: true, expectedLookup: 2},
+		// Same center and radius keep cached results
+		{rules: Rules{Place: "Paris, FR", Radius: 500}, expectedSource: SourceCache, expectedNear: true, expectedLookup: 2},
+		// Results decided against another center are looked up again
+		{rules: Rules{Place: "London, GB", Radius: 1}, expectedSource: SourceLookup, expectedNear: true, expectedLookup: 3},
+		{rules: Rules{Place: "London, GB", Radius: 1, Overrides: mustOverrides(t, Override{CIDR: netip.MustParsePrefix("8.8.8.0/24"), Verdict: VerdictDeny})}, expectedSource: SourceOverride, expectedLookup: 3},
+	}
+	for _, test := range tests {
+		err := geofence.Reload(test.rules)
+		assert.ErrorIs(t, err, test.err)
+		decision, err := geofence.Check("8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedSource, decision.Source)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear)
+		assert.Equal(t, test.expectedLookup, lookups)
+	}
+
+	assert.Equal(t, "London, GB", geofence.Rules().Place)
+	assert.Equal(t, 100.0, geofence.Config.Radius)
+}
+
+func mustOverrides(t *testing.T, overrides ...Override) *Overrides {
+	t.Helper()
+	o, err := NewOverrides(overrides)
+	assert.NoError(t, err)
+	return o
+}
+
+func TestGeofenceReloadDuringLookup(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		started <- struct{}{}
+		<-release
+		// London, about 340km from Paris
+		return &provider.Location{Latitude: 51.5072, Longitude: -0.1276}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider: fakeProvider,
+		Place:    "Paris, FR",
+		Radius:   100,
+		CacheTTL: -1,
+	})
+	assert.NoError(t, err)
+
+	decisions := make(chan *Decision)
+	go func() {
+		decision, err := geofence.Check("8.8.8.8")
+		assert.NoError(t, err)
+		decisions <- decision
+	}()
+
+	// Reload while the check is waiting for the provider
+	<-started
+	assert.NoError(t, geofence.Reload(Rules{Place: "Paris, FR", Radius: 500}))
+	close(release)
+
+	// The check in flight finishes with the rules it started with
+	decision := <-decisions
+	assert.Equal(t, SourceLookup, decision.Source)
+	assert.False(t, decision.IsIPAddressNear)
+
+	// and its result isn't served once the rules changed
+	decision, err = geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	<-started
+	assert.Equal(t, SourceLookup, decision.Source)
+	assert.True(t, decision.IsIPAddressNear)
+
+	decision, err = geofence.Check("8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, SourceCache, decision.Source)
+	assert.True(t, decision.IsIPAddressNear)
+}
+
+func TestGeofenceCachedCheckAllocations(t *testing.T) {
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{Latitude: 51.5072, Longitude: -0.1276}, nil
+	})
+	geofence, err := New(&Config{Provider: fakeProvider, Place: "Paris, FR", Radius: 100, CacheTTL: -1})
+	assert.NoError(t, err)
+
+	addr := netip.MustParseAddr("8.8.8.8")
+	_, err = geofence.CheckAddr(addr)
+	assert.NoError(t, err)
+
+	// The fingerprint of the rules is computed when they change, not for every cached result
+	var decision *Decision
+	allocations := testing.AllocsPerRun(100, func() {
+		decision, _ = geofence.CheckAddr(addr)
+	})
+	assert.Equal(t, SourceCache, decision.Source)
+	assert.LessOrEqual(t, allocations, 3.0)
+}
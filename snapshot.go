@@ -0,0 +1,215 @@
+package geofence
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/circa10a/go-geofence/cache"
+)
+
+const (
+	warmUpConcurrency = 4
+)
+
+// snapshotHeader is the first line of a cache snapshot
+type snapshotHeader struct {
+	// Fingerprint identifies the geofence the snapshot was taken of
+	Fingerprint string `json:"geofence_fingerprint"`
+}
+
+// snapshotRecord is a single line of a cache snapshot
+type snapshotRecord struct {
+	IPAddress string `json:"ip_address"`
+	cache.Entry
+}
+
+// fingerprint identifies the current geofence, empty while its center isn't known yet
+func (g *Geofence) fingerprint() string {
+	if atomic.LoadUint32(&g.center.located) == 0 {
+		return ""
+	}
+	return g.currentRules().fingerprintFor(g.Center())
+}
+
+// ExportCache writes the cached results to w as JSON lines, one ip address per line
+// after a header identifying the geofence they were decided against
+func (g *Geofence) ExportCache(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+
+	if fingerprint := g.fingerprint(); fingerprint != "" {
+		if err := encoder.Encode(&snapshotHeader{Fingerprint: fingerprint}); err != nil {
+			return err
+		}
+	}
+
+	var encodeErr error
+	err := g.RangeCache(func(ipAddress string, entry *cache.Entry) bool {
+		encodeErr = encoder.Encode(&snapshotRecord{
+			IPAddress: ipAddress,
+			Entry:     *entry,
+		})
+		return encodeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return encodeErr
+}
+
+// ImportCache loads cached results written by ExportCache.
+// Results keep their original age, so ones that have since expired are skipped.
+// A snapshot of a geofence with another center, radius, default accuracy radius, unit, distance formula or uncertain policy is skipped entirely.
+func (g *Geofence) ImportCache(r io.Reader) error {
+	fingerprint := g.fingerprint()
+
+	scanner := bufio.NewScanner(r)
+	for line, first := 1, true; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		if first {
+			first = false
+			header := &snapshotHeader{}
+			if err := json.Unmarshal(scanner.Bytes(), header); err != nil {
+				return fmt.Errorf("cache snapshot line %d: %w", line, err)
+			}
+			if header.Fingerprint != "" {
+				if fingerprint != "" && header.Fingerprint != fingerprint {
+					return nil
+				}
+				continue
+			}
+		}
+
+		record := &snapshotRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return fmt.Errorf("cache snapshot line %d: %w", line, err)
+		}
+		_, key, err := parseIPAddress(record.IPAddress)
+		if err != nil {
+			return fmt.Errorf("cache snapshot line %d: %w", line, err)
+		}
+		// Snapshots without a header may still hold results of another geofence
+		if fingerprint != "" && record.Fingerprint != "" && record.Fingerprint != fingerprint {
+			continue
+		}
+
+		if err := g.cache.Set(g.ctx, key, &record.Entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// SaveCacheSnapshot writes the cache to Config.CacheSnapshotFile.
+// The file is replaced atomically so a crash never leaves a partial snapshot.
+func (g *Geofence) SaveCacheSnapshot() error {
+	path := g.Config.CacheSnapshotFile
+	if path == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = g.ExportCache(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCacheSnapshot imports Config.CacheSnapshotFile if it exists
+func (g *Geofence) loadCacheSnapshot() error {
+	if g.Config.CacheSnapshotFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(g.Config.CacheSnapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return g.ImportCache(f)
+}
+
+// WarmUp looks up ip addresses in the background so their results are cached before they're needed.
+// Addresses that are already cached are skipped.
+// The returned channel receives an error for each address that couldn't be looked up
+// and is closed once every address has been processed.
+func (g *Geofence) WarmUp(ipAddresses []string) <-chan error {
+	errs := make(chan error, len(ipAddresses))
+	queue := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < warmUpConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ipAddress := range queue {
+				if err := g.warmUp(ipAddress); err != nil {
+					errs <- fmt.Errorf("%s: %w", ipAddress, err)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, ipAddress := range ipAddresses {
+			queue <- ipAddress
+		}
+		close(queue)
+		wg.Wait()
+		close(errs)
+	}()
+
+	return errs
+}
+
+// warmUp looks up a single ip address unless a fresh result is cached.
+// Overridden and special addresses are decided without a lookup, so they're skipped.
+func (g *Geofence) warmUp(ipAddress string) error {
+	addr, ipAddress, err := parseIPAddress(ipAddress)
+	if err != nil {
+		return err
+	}
+
+	rules := g.currentRules()
+	if decided, err := g.decideWithoutLookup(&Decision{IPAddress: ipAddress}, addr, rules); decided {
+		return err
+	}
+
+	entry, found, err := g.cache.Get(g.ctx, ipAddress)
+	if err != nil {
+		return err
+	}
+	if found && !entry.Expired && !entry.Stale && g.decidedBy(entry, rules) {
+		return nil
+	}
+
+	_, err = g.lookup(ipAddress, rules)
+	return err
+}
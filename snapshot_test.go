@@ -0,0 +1,260 @@
+package geofence
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence/cache"
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportCache(t *testing.T) {
+	source, _ := New(&Config{
+		Token:    "fakeApiToken",
+		CacheTTL: time.Hour,
+	})
+	entries := map[string]*cache.Entry{
+		"8.8.8.8": {StoredAt: time.Now().Add(-time.Minute), IsIPAddressNear: true},
+		"1.1.1.1": {StoredAt: time.Now().Add(-time.Minute), IsIPAddressNear: false},
+	}
+	for ipAddress, entry := range entries {
+		assert.NoError(t, source.cache.Set(context.TODO(), ipAddress, entry))
+	}
+
+	snapshot := &bytes.Buffer{}
+	assert.NoError(t, source.ExportCache(snapshot))
+	assert.Equal(t, 2, strings.Count(snapshot.String(), "\n"))
+
+	// Expired since it was exported
+	snapshot.WriteString(`{"ip_address":"9.9.9.9","stored_at":"2000-01-01T00:00:00Z","is_ip_address_near":true}` + "\n")
+
+	destination, _ := New(&Config{
+		Token:    "fakeApiToken",
+		CacheTTL: time.Hour,
+	})
+	assert.NoError(t, destination.ImportCache(snapshot))
+
+	length, err := destination.CacheLen()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, length)
+
+	for ipAddress, expected := range entries {
+		actual, found, err := destination.cache.Get(context.TODO(), ipAddress)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, expected.IsIPAddressNear, actual.IsIPAddressNear)
+		assert.WithinDuration(t, expected.StoredAt, actual.StoredAt, time.Millisecond)
+	}
+}
+
+func TestImportCacheInvalid(t *testing.T) {
+	tests := []struct {
+		input string
+	}{
+		{
+			input: "not json\n",
+		},
+		{
+			input: `{"ip_address":"8.8.88","is_ip_address_near":true}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		geofence, _ := New(&Config{Token: "fakeApiToken"})
+		err := geofence.ImportCache(strings.NewReader(test.input))
+		assert.ErrorContains(t, err, "line 1")
+	}
+}
+
+func TestImportCacheOtherGeofence(t *testing.T) {
+	london := &Coordinates{Latitude: 51.5074, Longitude: -0.1278}
+	paris := &Coordinates{Latitude: 48.8566, Longitude: 2.3522}
+
+	source, err := New(&Config{Token: "fakeApiToken", Center: london, Radius: 50, CacheTTL: time.Hour})
+	assert.NoError(t, err)
+	assert.NoError(t, source.cache.Set(context.TODO(), "8.8.8.8", &cache.Entry{IsIPAddressNear: true}))
+
+	snapshot := &bytes.Buffer{}
+	assert.NoError(t, source.ExportCache(snapshot))
+	assert.Equal(t, 2, strings.Count(snapshot.String(), "\n"))
+
+	tests := []struct {
+		rules          Rules
+		expectedLength int
+	}{
+		{
+			rules:          Rules{Center: london, Radius: 50},
+			expectedLength: 1,
+		},
+		{
+			rules:          Rules{Center: paris, Radius: 50},
+			expectedLength: 0,
+		},
+		{
+			rules:          Rules{Center: london, Radius: 100},
+			expectedLength: 0,
+		},
+	}
+	for _, test := range tests {
+		destination, err := New(&Config{Token: "fakeApiToken", Center: test.rules.Center, Radius: test.rules.Radius, CacheTTL: time.Hour})
+		assert.NoError(t, err)
+		assert.NoError(t, destination.ImportCache(bytes.NewReader(snapshot.Bytes())))
+
+		length, err := destination.CacheLen()
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedLength, length)
+	}
+}
+
+func TestCacheSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	center := &Coordinates{Latitude: 51.5074, Longitude: -0.1278}
+
+	// Missing snapshot is not an error
+	geofence, err := New(&Config{
+		Token:             "fakeApiToken",
+		Center:            center,
+		CacheTTL:          time.Hour,
+		CacheSnapshotFile: path,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, geofence.cache.Set(context.TODO(), "8.8.8.8", &cache.Entry{IsIPAddressNear: true}))
+	assert.NoError(t, geofence.Close())
+	assert.FileExists(t, path)
+
+	geofence, err = New(&Config{
+		Token:             "fakeApiToken",
+		Center:            center,
+		CacheTTL:          time.Hour,
+		CacheSnapshotFile: path,
+	})
+	assert.NoError(t, err)
+	entry, found, err := geofence.cache.Get(context.TODO(), "8.8.8.8")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, entry.IsIPAddressNear)
+
+	// Corrupt snapshot
+	assert.NoError(t, os.WriteFile(path, []byte("not json\n"), 0o600))
+	_, err = New(&Config{
+		Token:             "fakeApiToken",
+		Center:            center,
+		CacheSnapshotFile: path,
+	})
+	assert.ErrorContains(t, err, "cache snapshot")
+}
+
+func TestCacheSnapshotUnsupportedCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	geofence, err := New(&Config{
+		Token:             "fakeApiToken",
+		Center:            &Coordinates{Latitude: 51.5074, Longitude: -0.1278},
+		MemcachedOptions:  &cache.MemcachedOptions{Servers: []string{"127.0.0.1:11211"}},
+		CacheSnapshotFile: path,
+	})
+	assert.NoError(t, err)
+
+	// Saving is reported on demand, but doesn't fail Close
+	assert.ErrorIs(t, geofence.SaveCacheSnapshot(), cache.ErrNotSupported)
+	assert.NoError(t, geofence.Close())
+	assert.NoFileExists(t, path)
+}
+
+func TestRedisDefaultKeyPrefix(t *testing.T) {
+	tests := []struct {
+		keyPrefix string
+		expected  string
+	}{
+		{expected: cache.DefaultRedisKeyPrefix},
+		{keyPrefix: "myapp:", expected: "myapp:"},
+	}
+	for _, test := range tests {
+		redisOptions := &cache.RedisOptions{Addr: "localhost:6379", KeyPrefix: test.keyPrefix}
+		geofence, err := New(&Config{
+			Token:        "fakeApiToken",
+			Center:       &Coordinates{Latitude: 51.5074, Longitude: -0.1278},
+			RedisOptions: redisOptions,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, redisOptions.KeyPrefix)
+		assert.NoError(t, geofence.Close())
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	fakeApiToken := "fakeApiToken"
+	cachedIPAddress := "1.1.1.1"
+	ipAddresses := []string{"8.8.8.8", "8.8.4.4", cachedIPAddress, "8.8.88"}
+
+	geofence, _ := New(&Config{
+		Token:    fakeApiToken,
+		CacheTTL: time.Hour,
+	})
+	assert.NoError(t, geofence.cache.Set(context.TODO(), cachedIPAddress, &cache.Entry{IsIPAddressNear: true}))
+
+	httpmock.ActivateNonDefault(geofence.ipbaseClient.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	for _, ipAddress := range ipAddresses[:3] {
+		httpmock.RegisterResponder("GET", fmt.Sprintf(endpointStrTemplate, ipBaseBaseURL, fakeApiToken, ipAddress),
+			httpmock.NewJsonResponderOrPanic(200, &ipbaseResponse{}))
+	}
+
+	var errs []string
+	for err := range geofence.WarmUp(ipAddresses) {
+		errs = append(errs, err.Error())
+	}
+	sort.Strings(errs)
+	assert.Equal(t, []string{"8.8.88: " + ErrInvalidIPAddress.Error()}, errs)
+
+	length, err := geofence.CacheLen()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, length)
+
+	// Cached address was not looked up
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, 0, info[fmt.Sprintf("GET "+endpointStrTemplate, ipBaseBaseURL, fakeApiToken, cachedIPAddress)])
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+}
+
+func TestWarmUpSkipsAddressesDecidedWithoutLookup(t *testing.T) {
+	overrides, err := NewOverrides([]Override{
+		{CIDR: netip.MustParsePrefix("203.0.113.0/24"), Verdict: VerdictAllow},
+	})
+	assert.NoError(t, err)
+
+	var lookups int32
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		atomic.AddInt32(&lookups, 1)
+		return &provider.Location{}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider:                fakeProvider,
+		Center:                  &Coordinates{Latitude: 51.5074, Longitude: -0.1278},
+		Overrides:               overrides,
+		AllowPrivateIPAddresses: true,
+		CacheTTL:                time.Hour,
+	})
+	assert.NoError(t, err)
+
+	for err := range geofence.WarmUp([]string{"203.0.113.7", "10.0.0.1", "127.0.0.1", "8.8.8.8"}) {
+		assert.NoError(t, err)
+	}
+
+	// Only the address that needs a lookup was looked up and cached
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+	length, err := geofence.CacheLen()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, length)
+}
@@ -0,0 +1,151 @@
+package geofence
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/circa10a/go-geofence/provider"
+)
+
+const (
+	specialAddressProviderName = "special_address"
+)
+
+// AddressCategory is a category of the IANA special-purpose address registries
+type AddressCategory string
+
+const (
+	// CategoryPrivate is 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16 and the ipv6 unique local fc00::/7
+	CategoryPrivate AddressCategory = "private"
+	// CategoryLoopback is 127.0.0.0/8 and ::1
+	CategoryLoopback AddressCategory = "loopback"
+	// CategoryLinkLocal is 169.254.0.0/16 and fe80::/10
+	CategoryLinkLocal AddressCategory = "link_local"
+	// CategorySharedAddressSpace is 100.64.0.0/10, used by carrier-grade NAT
+	CategorySharedAddressSpace AddressCategory = "shared_address_space"
+	// CategoryDocumentation is 192.0.2.0/24, 198.51.100.0/24, 203.0.113.0/24, 2001:db8::/32 and 3fff::/20
+	CategoryDocumentation AddressCategory = "documentation"
+	// CategoryBenchmarking is 198.18.0.0/15 and 2001:2::/48
+	CategoryBenchmarking AddressCategory = "benchmarking"
+	// CategoryMulticast is 224.0.0.0/4 and ff00::/8
+	CategoryMulticast AddressCategory = "multicast"
+	// CategoryUnspecified is 0.0.0.0 and ::
+	CategoryUnspecified AddressCategory = "unspecified"
+	// CategoryReserved is every other special-purpose range that isn't globally reachable,
+	// such as 0.0.0.0/8, 240.0.0.0/4, the broadcast address, teredo 2001::/32 and the deprecated orchid 2001:10::/28
+	CategoryReserved AddressCategory = "reserved"
+)
+
+// VerdictLookup looks up special-purpose addresses with the provider like any other address
+const VerdictLookup Verdict = "lookup"
+
+// SpecialAddressPolicy decides the addresses of an AddressCategory
+type SpecialAddressPolicy struct {
+	// Verdict defaults to VerdictLookup. VerdictLocation places the addresses at Latitude and Longitude.
+	Verdict   Verdict
+	Country   string
+	Latitude  float64
+	Longitude float64
+}
+
+type specialRange struct {
+	category AddressCategory
+	prefix   netip.Prefix
+}
+
+// specialRanges are the special-purpose ranges that aren't globally reachable, more specific ranges first.
+// See https://www.iana.org/assignments/iana-ipv4-special-registry and
+// https://www.iana.org/assignments/iana-ipv6-special-registry
+var specialRanges = []specialRange{
+	{category: CategoryUnspecified, prefix: netip.MustParsePrefix("0.0.0.0/32")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("0.0.0.0/8")},
+	{category: CategoryPrivate, prefix: netip.MustParsePrefix("10.0.0.0/8")},
+	{category: CategorySharedAddressSpace, prefix: netip.MustParsePrefix("100.64.0.0/10")},
+	{category: CategoryLoopback, prefix: netip.MustParsePrefix("127.0.0.0/8")},
+	{category: CategoryLinkLocal, prefix: netip.MustParsePrefix("169.254.0.0/16")},
+	{category: CategoryPrivate, prefix: netip.MustParsePrefix("172.16.0.0/12")},
+	{category: CategoryDocumentation, prefix: netip.MustParsePrefix("192.0.2.0/24")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("192.0.0.0/24")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("192.88.99.0/24")},
+	{category: CategoryPrivate, prefix: netip.MustParsePrefix("192.168.0.0/16")},
+	{category: CategoryBenchmarking, prefix: netip.MustParsePrefix("198.18.0.0/15")},
+	{category: CategoryDocumentation, prefix: netip.MustParsePrefix("198.51.100.0/24")},
+	{category: CategoryDocumentation, prefix: netip.MustParsePrefix("203.0.113.0/24")},
+	{category: CategoryMulticast, prefix: netip.MustParsePrefix("224.0.0.0/4")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("240.0.0.0/4")},
+
+	{category: CategoryUnspecified, prefix: netip.MustParsePrefix("::/128")},
+	{category: CategoryLoopback, prefix: netip.MustParsePrefix("::1/128")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("64:ff9b:1::/48")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("100::/64")},
+	// Other ietf protocol assignments in 2001::/23, such as amt 2001:3::/32 and orchidv2 2001:20::/28, are globally reachable
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("2001::/32")},
+	{category: CategoryBenchmarking, prefix: netip.MustParsePrefix("2001:2::/48")},
+	{category: CategoryReserved, prefix: netip.MustParsePrefix("2001:10::/28")},
+	{category: CategoryDocumentation, prefix: netip.MustParsePrefix("2001:db8::/32")},
+	{category: CategoryDocumentation, prefix: netip.MustParsePrefix("3fff::/20")},
+	{category: CategoryPrivate, prefix: netip.MustParsePrefix("fc00::/7")},
+	{category: CategoryLinkLocal, prefix: netip.MustParsePrefix("fe80::/10")},
+	{category: CategoryMulticast, prefix: netip.MustParsePrefix("ff00::/8")},
+}
+
+// SpecialAddressCategory returns the category of a special-purpose address.
+// It returns false for globally reachable addresses, ipv4-mapped addresses are categorized as ipv4.
+func SpecialAddressCategory(addr netip.Addr) (AddressCategory, bool) {
+	addr = addr.Unmap().WithZone("")
+	for _, r := range specialRanges {
+		if r.prefix.Contains(addr) {
+			return r.category, true
+		}
+	}
+	return "", false
+}
+
+// validateSpecialAddresses ensures every policy has a known verdict and valid coordinates
+func validateSpecialAddresses(policies map[AddressCategory]SpecialAddressPolicy) error {
+	for category, policy := range policies {
+		switch policy.Verdict {
+		case "", VerdictLookup, VerdictAllow, VerdictDeny:
+		case VerdictLocation:
+			coordinates := Coordinates{Latitude: policy.Latitude, Longitude: policy.Longitude}
+			if !coordinates.valid() {
+				return fmt.Errorf("%w: special addresses %s %v", ErrInvalidCoordinates, category, coordinates)
+			}
+		default:
+			return fmt.Errorf("%w: special addresses %s %q", ErrInvalidVerdict, category, policy.Verdict)
+		}
+	}
+	return nil
+}
+
+// applySpecialAddressPolicy decides for a special-purpose address if its category isn't looked up.
+// It returns false if the address should be looked up.
+func (g *Geofence) applySpecialAddressPolicy(decision *Decision, addr netip.Addr, rules *Rules) (bool, error) {
+	category, special := SpecialAddressCategory(addr)
+	if !special {
+		return false, nil
+	}
+
+	policy, configured := rules.SpecialAddresses[category]
+	if !configured {
+		if rules.AllowPrivateIPAddresses && (category == CategoryPrivate || category == CategoryLoopback) {
+			decision.Source = SourcePrivate
+			decision.Category = category
+			decision.IsIPAddressNear = true
+			return true, nil
+		}
+		return false, nil
+	}
+	if policy.Verdict == "" || policy.Verdict == VerdictLookup {
+		return false, nil
+	}
+
+	decision.Source = SourceSpecialAddress
+	decision.Category = category
+	return true, g.applyVerdict(decision, policy.Verdict, rules, &provider.Location{
+		Provider:  specialAddressProviderName,
+		Country:   policy.Country,
+		Latitude:  policy.Latitude,
+		Longitude: policy.Longitude,
+	})
+}
@@ -0,0 +1,110 @@
+package geofence
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpecialAddressCategory(t *testing.T) {
+	tests := []struct {
+		ipAddress        string
+		expectedCategory AddressCategory
+		special          bool
+	}{
+		{ipAddress: "0.0.0.0", expectedCategory: CategoryUnspecified, special: true},
+		{ipAddress: "0.1.2.3", expectedCategory: CategoryReserved, special: true},
+		{ipAddress: "10.1.2.3", expectedCategory: CategoryPrivate, special: true},
+		{ipAddress: "100.64.0.1", expectedCategory: CategorySharedAddressSpace, special: true},
+		{ipAddress: "127.0.0.1", expectedCategory: CategoryLoopback, special: true},
+		{ipAddress: "169.254.169.254", expectedCategory: CategoryLinkLocal, special: true},
+		{ipAddress: "192.0.2.1", expectedCategory: CategoryDocumentation, special: true},
+		{ipAddress: "198.19.0.1", expectedCategory: CategoryBenchmarking, special: true},
+		{ipAddress: "239.255.255.250", expectedCategory: CategoryMulticast, special: true},
+		{ipAddress: "255.255.255.255", expectedCategory: CategoryReserved, special: true},
+		{ipAddress: "::ffff:192.168.1.1", expectedCategory: CategoryPrivate, special: true},
+		{ipAddress: "::", expectedCategory: CategoryUnspecified, special: true},
+		{ipAddress: "::1", expectedCategory: CategoryLoopback, special: true},
+		{ipAddress: "fd00::1", expectedCategory: CategoryPrivate, special: true},
+		{ipAddress: "fe80::1%eth0", expectedCategory: CategoryLinkLocal, special: true},
+		{ipAddress: "2001:db8::1", expectedCategory: CategoryDocumentation, special: true},
+		{ipAddress: "2001:2::1", expectedCategory: CategoryBenchmarking, special: true},
+		{ipAddress: "2001:0:4136:e378::1", expectedCategory: CategoryReserved, special: true},
+		{ipAddress: "2001:10::1", expectedCategory: CategoryReserved, special: true},
+		// Globally reachable ietf protocol assignments
+		{ipAddress: "2001:1::1"},
+		{ipAddress: "2001:3::1"},
+		{ipAddress: "2001:4:112::1"},
+		{ipAddress: "2001:20::1"},
+		{ipAddress: "2001:30::1"},
+		{ipAddress: "ff02::1", expectedCategory: CategoryMulticast, special: true},
+		{ipAddress: "8.8.8.8"},
+		{ipAddress: "100.128.0.1"},
+		{ipAddress: "2606:4700::1111"},
+	}
+	for _, test := range tests {
+		category, special := SpecialAddressCategory(netip.MustParseAddr(test.ipAddress))
+		assert.Equal(t, test.special, special, test.ipAddress)
+		assert.Equal(t, test.expectedCategory, category, test.ipAddress)
+	}
+}
+
+func TestGeofenceSpecialAddresses(t *testing.T) {
+	lookups := 0
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		lookups++
+		return &provider.Location{Latitude: 37.751, Longitude: -97.822}, nil
+	})
+
+	geofence, err := New(&Config{
+		Provider: fakeProvider,
+		Radius:   1,
+		CacheTTL: time.Hour,
+		SpecialAddresses: map[AddressCategory]SpecialAddressPolicy{
+			CategoryLinkLocal:          {Verdict: VerdictDeny},
+			CategoryMulticast:          {Verdict: VerdictDeny},
+			CategorySharedAddressSpace: {Verdict: VerdictLocation, Latitude: 37.751, Longitude: -97.822},
+			CategoryPrivate:            {Verdict: VerdictLookup},
+		},
+		AllowPrivateIPAddresses: true,
+	})
+	assert.NoError(t, err)
+	lookups = 0
+
+	tests := []struct {
+		ipAddress        string
+		expectedSource   Source
+		expectedCategory AddressCategory
+		expectedNear     bool
+	}{
+		{ipAddress: "169.254.1.1", expectedSource: SourceSpecialAddress, expectedCategory: CategoryLinkLocal},
+		{ipAddress: "ff02::1", expectedSource: SourceSpecialAddress, expectedCategory: CategoryMulticast},
+		{ipAddress: "100.64.0.1", expectedSource: SourceSpecialAddress, expectedCategory: CategorySharedAddressSpace, expectedNear: true},
+		// Loopback isn't configured so AllowPrivateIPAddresses applies
+		{ipAddress: "127.0.0.1", expectedSource: SourcePrivate, expectedCategory: CategoryLoopback, expectedNear: true},
+		// Private is explicitly looked up despite AllowPrivateIPAddresses
+		{ipAddress: "10.0.0.1", expectedSource: SourceLookup, expectedNear: true},
+		// Unconfigured categories are looked up
+		{ipAddress: "192.0.2.1", expectedSource: SourceLookup, expectedNear: true},
+	}
+	for _, test := range tests {
+		decision, err := geofence.Check(test.ipAddress)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedSource, decision.Source, test.ipAddress)
+		assert.Equal(t, test.expectedCategory, decision.Category, test.ipAddress)
+		assert.Equal(t, test.expectedNear, decision.IsIPAddressNear, test.ipAddress)
+	}
+	assert.Equal(t, 2, lookups)
+
+	_, err = New(&Config{
+		Provider: fakeProvider,
+		SpecialAddresses: map[AddressCategory]SpecialAddressPolicy{
+			CategoryMulticast: {Verdict: "maybe"},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package geofence
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMissingToken is returned by New when the default ipbase.com provider is used without Config.Token
+	ErrMissingToken = errors.New("missing ipbase.com api token")
+	// ErrInvalidRadius is returned by New when Config.Radius is negative, infinite or NaN
+	ErrInvalidRadius = errors.New("invalid radius")
+	// ErrInvalidCoordinates is returned by New for latitudes outside [-90, 90], longitudes outside [-180, 180] or NaN
+	ErrInvalidCoordinates = errors.New("invalid coordinates")
+	// ErrInvalidFailurePolicy is returned by New for an unknown Config.FailurePolicy
+	ErrInvalidFailurePolicy = errors.New("invalid failure policy")
+	// ErrInvalidUncertainPolicy is returned by New for an unknown Config.UncertainPolicy
+	ErrInvalidUncertainPolicy = errors.New("invalid uncertain policy")
+	// ErrInvalidVerdict is returned by New for an unknown verdict in Config.SpecialAddresses
+	ErrInvalidVerdict = errors.New("invalid verdict")
+	// ErrInvalidUnit is returned by New for an unknown Config.Unit
+	ErrInvalidUnit = errors.New("invalid unit")
+	// ErrInvalidDistanceFormula is returned by New for an unknown Config.DistanceFormula
+	ErrInvalidDistanceFormula = errors.New("invalid distance formula")
+)
+
+// validate ensures the configuration makes sense before anything is set up
+func (c *Config) validate() error {
+	if c.Provider == nil && c.Token == "" {
+		return ErrMissingToken
+	}
+	if c.IPAddress != "" {
+		if _, _, err := parseIPAddress(c.IPAddress); err != nil {
+			return fmt.Errorf("%w: %q", err, c.IPAddress)
+		}
+	}
+	return c.rules().validate()
+}
+
+// valid reports whether the coordinates are on earth
+func (c Coordinates) valid() bool {
+	return c.Latitude >= -90 && c.Latitude <= 90 && c.Longitude >= -180 && c.Longitude <= 180
+}
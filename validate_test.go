@@ -0,0 +1,61 @@
+package geofence
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/circa10a/go-geofence/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	fakeProvider := provider.Func(func(ctx context.Context, ipAddress string) (*provider.Location, error) {
+		return &provider.Location{}, nil
+	})
+
+	tests := []struct {
+		config *Config
+		err    error
+	}{
+		{config: &Config{Token: "fakeApiToken", Radius: 1}},
+		{config: &Config{Provider: fakeProvider, CacheTTL: -1}},
+		// Every negative ttl caches indefinitely
+		{config: &Config{Provider: fakeProvider, CacheTTL: -time.Hour}},
+		{config: &Config{Provider: fakeProvider, Center: &Coordinates{Latitude: -90, Longitude: 180}}},
+		{config: &Config{}, err: ErrMissingToken},
+		{config: &Config{Provider: fakeProvider, IPAddress: "8.8.88"}, err: ErrInvalidIPAddress},
+		{config: &Config{Provider: fakeProvider, Radius: -1}, err: ErrInvalidRadius},
+		{config: &Config{Provider: fakeProvider, Radius: math.NaN()}, err: ErrInvalidRadius},
+		{config: &Config{Provider: fakeProvider, Radius: math.Inf(1)}, err: ErrInvalidRadius},
+		{config: &Config{Provider: fakeProvider, Center: &Coordinates{Latitude: 91}}, err: ErrInvalidCoordinates},
+		{config: &Config{Provider: fakeProvider, Center: &Coordinates{Longitude: math.NaN()}}, err: ErrInvalidCoordinates},
+		{config: &Config{Provider: fakeProvider, FailurePolicy: FailurePolicy(42)}, err: ErrInvalidFailurePolicy},
+		{
+			config: &Config{Provider: fakeProvider, SpecialAddresses: map[AddressCategory]SpecialAddressPolicy{
+				CategoryLinkLocal: {Verdict: "maybe"},
+			}},
+			err: ErrInvalidVerdict,
+		},
+		{
+			config: &Config{Provider: fakeProvider, SpecialAddresses: map[AddressCategory]SpecialAddressPolicy{
+				CategoryLinkLocal: {Verdict: VerdictLocation, Longitude: 200},
+			}},
+			err: ErrInvalidCoordinates,
+		},
+	}
+	for _, test := range tests {
+		err := test.config.validate()
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err)
+			continue
+		}
+		assert.NoError(t, err)
+	}
+
+	// New doesn't set anything up for an invalid config
+	geofence, err := New(&Config{})
+	assert.ErrorIs(t, err, ErrMissingToken)
+	assert.Nil(t, geofence)
+}